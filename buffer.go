@@ -0,0 +1,51 @@
+package hh
+
+import "io"
+
+// A Buffer holds the body of a wrapped handler's response until it is sent.
+// The default implementation is a bytes.Buffer.
+type Buffer interface {
+	io.Writer
+	Len() int      // the number of bytes written
+	Bytes() []byte // the bytes written; valid until the next call to Write or Reset
+	Reset()        // called when Wrap has finished with the buffer
+}
+
+// WithBufferFactory uses newBuffer to create the Buffer for each response body.
+// This allows pooled or instrumented buffers.
+//
+// Wrap calls Reset on each buffer it receives from newBuffer when it has finished with it:
+// after the response has been sent, or when a handler's output is discarded (see WithRetry).
+// After Reset, Wrap does not use the buffer again,
+// so an implementation may return itself to a pool there.
+// Buffers belonging to handlers abandoned because of a timeout are never Reset,
+// because the abandoned handler may still be writing to them.
+func WithBufferFactory(newBuffer func() Buffer) Option {
+	return func(wr *Wrapper) {
+		wr.newBuffer = newBuffer
+	}
+}
+
+// WithMaxBufferSize limits the response body that Wrap buffers in memory to n bytes.
+//
+// A body of up to n bytes is buffered as usual.
+// When a Write would take the body past n bytes, the response switches to pass-through mode:
+// the status code, the headers set so far, and the buffered bytes are sent to the client,
+// and that Write and all subsequent ones go directly to the client.
+// After the switch, the response is committed.
+// Changes to the header have no effect, an error returned by the handler cannot be rendered,
+// and the error is passed through errorware and reported to observers such as WithAfterRequest,
+// but the client receives whatever the handler wrote.
+// Checks that inspect the whole response, such as WithEnforceJSON and WithResponseHook, are skipped,
+// and the request is not retried.
+//
+// A hook set by WithOversizeHook checks the size of the body at the switch, not after the handler returns.
+//
+// Handlers subject to a time limit (see TimeoutHandler and WithTimeouts) are always fully buffered,
+// because a handler that has timed out must not write to the client.
+// If n is 0, the default, there is no limit.
+func WithMaxBufferSize(n int64) Option {
+	return func(wr *Wrapper) {
+		wr.maxBuffer = n
+	}
+}
@@ -0,0 +1,173 @@
+package hh
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithResponseCache caches successful responses in memory.
+//
+// key returns the cache key for a request, and whether its response may be cached at all.
+// The key should include everything that affects the response,
+// typically at least the URL, and any headers the response varies on.
+// Only GET requests use the cache.
+//
+// A response is stored only if the handler returned nil, with a 2xx status code,
+// and the response has no Cache-Control: no-store directive, no Set-Cookie header or cookies set using Cookies, and no trailers,
+// and was not too large to buffer (see WithMaxBufferSize).
+// Errors are never cached.
+// A stored response is served, in place of calling the handler, until ttl has elapsed since it was stored,
+// with an Age header (see SetAge) giving its age.
+// Response hooks, errorware, and other options apply to cached responses as to any other.
+//
+// The cache holds at most maxEntries responses; if maxEntries is not positive, it holds 1000.
+// When it is full, the oldest response is evicted to make room for a new one.
+// Since there is no limit on the size of each response, the memory used is bounded
+// only by maxEntries times the size of the largest response; use WithMaxBufferSize to bound that, too.
+//
+// Concurrent requests that miss the cache all call the handler; to coalesce them, also use WithSingleFlight.
+// Each call to Wrap creates a separate cache.
+func WithResponseCache(ttl time.Duration, maxEntries int, key func(*http.Request) (string, bool)) Option {
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	return func(wr *Wrapper) {
+		wr.cacheTTL = ttl
+		wr.cacheMax = maxEntries
+		wr.cacheKey = key
+	}
+}
+
+// DoNotCache marks the response being written to w as not cacheable,
+// for handlers that decide at run time that a response must not be cached,
+// for example because it contains data specific to the user.
+// It sets w's Cache-Control header to no-store, for the benefit of other caches,
+// and prevents WithResponseCache from storing the response,
+// even if the handler later changes the Cache-Control header.
+func DoNotCache(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	if bufw := bufferOf(w); bufw != nil {
+		bufw.noCache = true
+	}
+}
+
+// A cacheEntry is a response stored by WithResponseCache.
+type cacheEntry struct {
+	key     string
+	stored  time.Time
+	expires time.Time
+	code    int // 0 if the handler did not call WriteHeader
+	header  http.Header
+	body    []byte
+}
+
+// A responseCache is the cache for a handler wrapped with WithResponseCache.
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	order   []*cacheEntry // in order stored, and therefore of expiry; may contain replaced entries
+}
+
+// responseCache implements WithResponseCache.
+func (wr *Wrapper) responseCache(h HandlerFunc) HandlerFunc {
+	ttl, maxEntries, key := wr.cacheTTL, wr.cacheMax, wr.cacheKey
+	c := &responseCache{entries: make(map[string]*cacheEntry)}
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet {
+			return h(w, r)
+		}
+		k, ok := key(r)
+		if !ok {
+			return h(w, r)
+		}
+		now := time.Now()
+		if e := c.get(k, now); e != nil {
+			e.replay(w, now)
+			return nil
+		}
+		if err := h(w, r); err != nil {
+			return err
+		}
+		if bufw := bufferOf(w); bufw != nil && cacheable(bufw) {
+			c.put(&cacheEntry{
+				key:     k,
+				stored:  now,
+				expires: now.Add(ttl),
+				code:    bufw.code,
+				header:  bufw.header.Clone(),
+				body:    slices.Clone(bufw.buffer.Bytes()),
+			}, maxEntries)
+		}
+		return nil
+	}
+}
+
+// cacheable reports whether the response buffered in bufw, whose handler succeeded, may be cached.
+func cacheable(bufw *bufferingResponseWriter) bool {
+	if bufw.err != nil || bufw.passThrough || bufw.late != nil || bufw.noCache {
+		return false
+	}
+	if bufw.wroteCode && (bufw.code < 200 || bufw.code > 299) {
+		return false
+	}
+	if bufw.header.Get("Set-Cookie") != "" || bufw.cookies != nil && len(bufw.cookies.cookies) > 0 {
+		return false
+	}
+	for _, v := range bufw.header.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), "no-store") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// get returns the unexpired entry for k, or nil if there is none.
+func (c *responseCache) get(k string, now time.Time) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[k]
+	if e == nil || !now.Before(e.expires) {
+		return nil
+	}
+	return e
+}
+
+// put stores e, evicting expired entries, and then the oldest entries,
+// so that there are at most maxEntries.
+func (c *responseCache) put(e *cacheEntry, maxEntries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.order) > 0 {
+		old := c.order[0]
+		if c.entries[old.key] == old {
+			if len(c.entries) < maxEntries && e.stored.Before(old.expires) {
+				break
+			}
+			delete(c.entries, old.key)
+		}
+		c.order[0] = nil
+		c.order = c.order[1:]
+	}
+	c.entries[e.key] = e
+	c.order = append(c.order, e)
+}
+
+// replay writes a copy of e's response to w, at time now.
+func (e *cacheEntry) replay(w http.ResponseWriter, now time.Time) {
+	h := w.Header()
+	for k, v := range e.header {
+		h[k] = slices.Clone(v)
+	}
+	SetAge(w, now.Sub(e.stored))
+	if e.code != 0 {
+		w.WriteHeader(e.code)
+	}
+	if len(e.body) > 0 {
+		_, _ = w.Write(e.body)
+	}
+}
@@ -0,0 +1,153 @@
+package hh
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIP returns the IP address of the client that made r, without a port.
+//
+// If the immediate peer (r.RemoteAddr) is not in any of trustedProxies,
+// it is the client, and forwarding headers are ignored: they are trivially spoofed.
+// Otherwise, ClientIP consults the Forwarded header (RFC 7239),
+// or X-Forwarded-For if there is no Forwarded header.
+// The addresses listed there are examined from nearest hop to farthest (right to left).
+// The first address that is not in trustedProxies is the client.
+// If every address is trusted, the farthest is the client.
+// If an entry cannot be parsed (an obfuscated identifier such as "unknown", say),
+// the walk stops, and the last trusted hop is returned.
+//
+// ClientIP is suitable for keying rate limits and for logging.
+func ClientIP(r *http.Request, trustedProxies ...netip.Prefix) string {
+	peer, ok := parseHostIP(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !inPrefixes(peer, trustedProxies) {
+		return peer.String()
+	}
+	var hops []string
+	if fwd := r.Header.Values("Forwarded"); len(fwd) > 0 {
+		hops = forwardedFor(fwd)
+	} else {
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHostIP(strings.TrimSpace(hops[i]))
+		if !ok {
+			break
+		}
+		client = addr
+		if !inPrefixes(addr, trustedProxies) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedFor returns the for= parameters of the Forwarded header values vals, in order.
+// Elements without a for= parameter are reported as empty strings, which do not parse as addresses.
+func forwardedFor(vals []string) []string {
+	var hops []string
+	for _, v := range vals {
+		for _, elem := range splitQuoted(v, ',') {
+			var hop string
+			for _, pair := range splitQuoted(elem, ';') {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hop = strings.Trim(v, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits s around each instance of sep that is not inside a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted {
+				i++
+			}
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseHostIP parses s, an IP address with an optional port,
+// such as "192.0.2.1", "192.0.2.1:80", "2001:db8::1", or "[2001:db8::1]:80".
+func parseHostIP(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+func inPrefixes(addr netip.Addr, prefixes []netip.Prefix) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithTrustedProxies declares the addresses of reverse proxies in front of the server.
+// Options that need the client's address use ClientIP with these proxies.
+func WithTrustedProxies(prefixes ...netip.Prefix) Option {
+	return func(wr *Wrapper) {
+		wr.trustedProxies = append(wr.trustedProxies, prefixes...)
+	}
+}
+
+// WithInternalNetworks reveals error details to clients in prefixes.
+//
+// Errors that are not HTTPResponseErrors are normally converted to opaque 500s.
+// When the client's address, as determined by ClientIP, is in one of prefixes,
+// the body of the 500 also includes the error's text.
+// This is convenient for internal tools and debugging.
+// Clients whose address cannot be determined are treated as external.
+//
+// If the server is behind a reverse proxy, declare it with WithTrustedProxies.
+// Otherwise, every request appears to come from the proxy,
+// and if the proxy's address is in prefixes, every client sees error details.
+func WithInternalNetworks(prefixes ...netip.Prefix) Option {
+	return func(wr *Wrapper) {
+		wr.internalNetworks = append(wr.internalNetworks, prefixes...)
+	}
+}
+
+// isInternal reports whether r comes from one of wr's internal networks.
+func (wr *Wrapper) isInternal(r *http.Request) bool {
+	if len(wr.internalNetworks) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ClientIP(r, wr.trustedProxies...))
+	if err != nil {
+		return false
+	}
+	return inPrefixes(addr, wr.internalNetworks)
+}
@@ -0,0 +1,115 @@
+package hh
+
+import (
+	"bytes"
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WithGzip compresses successful response bodies of at least minSize bytes using gzip,
+// for clients that accept it.
+//
+// Because the whole body is buffered, the decision is made with full knowledge of it.
+// A body is compressed only if the request's Accept-Encoding header accepts gzip,
+// the handler did not set a Content-Encoding, its Content-Type is a textual type
+// such as text/html or application/json, and compression makes it smaller.
+// Already-compressed types, such as images and archives, are sent as they are.
+// Compressed responses have Content-Encoding: gzip, a corrected Content-Length, if any,
+// and a weakened ETag, if any, since the bytes sent differ from those the ETag was computed over.
+// Their Accept-Ranges header, if any, is removed, since ranges are not of the compressed body.
+// Responses that could be compressed have Vary: Accept-Encoding, whether or not they are.
+//
+// Compression is a response hook at EncodeStage (see WithStagedResponseHook),
+// so it runs after other hooks, such as those computing digests of the content.
+func WithGzip(minSize int) Option {
+	return WithStagedResponseHook(EncodeStage, func(r *http.Request, resp *BufferedResponse) error {
+		gzipResponse(r, resp, minSize)
+		return nil
+	})
+}
+
+// gzipWriters is a pool of *gzip.Writer, for WithGzip.
+var gzipWriters sync.Pool
+
+// gzipResponse implements WithGzip.
+func gzipResponse(r *http.Request, resp *BufferedResponse, minSize int) {
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return
+	}
+	h := resp.Header
+	if h.Get("Content-Encoding") != "" || !compressible(h.Get("Content-Type")) {
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	if len(resp.Body) < minSize || !acceptsGzip(r) {
+		return
+	}
+	var buf bytes.Buffer
+	zw, _ := gzipWriters.Get().(*gzip.Writer)
+	if zw == nil {
+		zw = gzip.NewWriter(&buf)
+	} else {
+		zw.Reset(&buf)
+	}
+	zw.Write(resp.Body) // writes to a bytes.Buffer cannot fail
+	zw.Close()
+	gzipWriters.Put(zw)
+	if buf.Len() >= len(resp.Body) {
+		return
+	}
+	resp.Body = buf.Bytes()
+	h.Set("Content-Encoding", "gzip")
+	// Ranges, as served by ServeBytes, are of the uncompressed body;
+	// a client resuming a compressed download would splice the two.
+	h.Del("Accept-Ranges")
+	if h.Get("Content-Length") != "" {
+		h.Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	}
+	if etag := h.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("Etag", "W/"+etag)
+	}
+}
+
+// compressible reports whether a body with Content-Type ct is worth compressing.
+func compressible(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		strings.HasSuffix(mt, "+json"),
+		strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	switch mt {
+	case "application/json", "application/xml", "application/javascript", "application/wasm":
+		return true
+	}
+	return false
+}
+
+// acceptsGzip reports whether r's Accept-Encoding header accepts gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(enc, ";")
+			name = strings.TrimSpace(name)
+			if !strings.EqualFold(name, "gzip") && !strings.EqualFold(name, "x-gzip") && name != "*" {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(q, 64); err == nil && f == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package hh
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGzip(t *testing.T) {
+	const minSize = 1 << 10
+	tests := []struct {
+		name     string
+		size     int
+		ct       string
+		encoding string // Content-Encoding set by the handler
+		want     bool   // whether the response is compressed
+	}{
+		{"below threshold", minSize - 1, "text/plain", "", false},
+		{"at threshold", minSize, "text/plain", "", true},
+		{"above threshold", minSize + 1, "application/json", "", true},
+		{"already encoded", minSize * 4, "text/plain", "br", false},
+		{"already compressed type", minSize * 4, "image/png", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat("a", tt.size)
+			h := func(w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("Content-Type", tt.ct)
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Write([]byte(body))
+				return nil
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip, deflate")
+			rec := httptest.NewRecorder()
+			NewWrapper(WithGzip(minSize)).Wrap(h)(rec, r)
+			enc := rec.Header().Get("Content-Encoding")
+			if tt.want {
+				if enc != "gzip" {
+					t.Errorf("Content-Encoding = %q, want gzip", enc)
+				}
+				if rec.Body.Len() >= tt.size {
+					t.Errorf("compressed body is %d bytes, not smaller than %d", rec.Body.Len(), tt.size)
+				}
+				return
+			}
+			if enc != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", enc, tt.encoding)
+			}
+			if rec.Body.String() != body {
+				t.Errorf("body changed: got %d bytes, want the %d written", rec.Body.Len(), tt.size)
+			}
+		})
+	}
+}
+
+func TestGzipAcceptRanges(t *testing.T) {
+	content := []byte(strings.Repeat("range me ", 1<<8))
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		ServeBytes(w, r, content, time.Time{}, "text/plain")
+		return nil
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	NewWrapper(WithGzip(0)).Wrap(h)(rec, r)
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	if got := rec.Header().Get("Accept-Ranges"); got != "" {
+		t.Errorf("compressed response has Accept-Ranges: %s", got)
+	}
+}
@@ -0,0 +1,155 @@
+package hh
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CheckIfUnmodifiedSince evaluates r's If-Unmodified-Since precondition
+// against a resource last modified at modtime.
+// It returns ErrPreconditionFailed if the resource was modified after the header's time, and nil otherwise.
+//
+// As specified by RFC 9110, section 13.1.4, the header is ignored (and CheckIfUnmodifiedSince returns nil)
+// if it is absent or not a valid HTTP-date, if r also has an If-Match header
+// (which takes precedence), or if modtime is the zero time (unknown).
+// Since HTTP-dates have one second resolution, modtime is truncated to the second before comparing.
+func CheckIfUnmodifiedSince(r *http.Request, modtime time.Time) error {
+	if r.Header.Get("If-Match") != "" || modtime.IsZero() {
+		return nil
+	}
+	ius := r.Header.Get("If-Unmodified-Since")
+	if ius == "" {
+		return nil
+	}
+	t, err := http.ParseTime(ius)
+	if err != nil {
+		return nil
+	}
+	if modtime.Truncate(time.Second).After(t) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// RequireConditional returns ErrPreconditionRequired if r is an unconditional write:
+// a request with an unsafe method (such as PUT, POST, PATCH, or DELETE)
+// that has neither an If-Match nor an If-Unmodified-Since header.
+// Otherwise it returns nil.
+//
+// Requiring conditional writes prevents lost updates:
+// clients must demonstrate that they are modifying the version of the resource they last saw.
+// See also CheckIfUnmodifiedSince.
+func RequireConditional(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return nil
+	}
+	if r.Header.Get("If-Match") != "" || r.Header.Get("If-Unmodified-Since") != "" {
+		return nil
+	}
+	return ErrPreconditionRequired
+}
+
+// CheckIfNoneMatch evaluates r's If-None-Match precondition against a resource whose current entity tag is etag,
+// a quoted string such as `"v2"` or `W/"v2"`.
+// If the header matches, CheckIfNoneMatch returns an error that renders as a 304 (Not Modified)
+// with an ETag header for GET and HEAD requests, and ErrPreconditionFailed for other methods.
+// Otherwise, including if the header is absent, it returns nil.
+//
+// As specified by RFC 9110, section 13.1.2, tags are compared using the weak comparison function,
+// so W/"v2" matches "v2", and the value * matches any current representation.
+// An empty etag means the resource does not exist, which nothing matches.
+func CheckIfNoneMatch(r *http.Request, etag string) error {
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" || etag == "" {
+		return nil
+	}
+	if !etagListMatch(inm, etag) {
+		return nil
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ErrPreconditionFailed
+	}
+	return &response{code: http.StatusNotModified, header: http.Header{"Etag": {etag}}}
+}
+
+// IfRange reports whether r's Range header should be honored,
+// for a resource whose current entity tag is etag and that was last modified at modtime.
+// Either may be empty or zero if unknown.
+// Handlers that parse Range themselves, such as those using PartialContent,
+// should send the full representation with a 200 (OK) when IfRange returns false.
+// ServeBytes does this itself.
+//
+// As specified by RFC 9110, section 13.1.5, IfRange returns true if r has no If-Range header.
+// An entity tag in If-Range matches only using the strong comparison function,
+// so weak tags never match.
+// A date matches only if it is exactly modtime, truncated to the second.
+func IfRange(r *http.Request, etag string, modtime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if tag, rest := scanETag(ir); tag != "" {
+		return strings.TrimSpace(rest) == "" && etag != "" && etagMatch(tag, etag, true)
+	}
+	if modtime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && t.Equal(modtime.Truncate(time.Second))
+}
+
+// etagListMatch reports whether the If-None-Match header value list matches etag,
+// using the weak comparison function.
+func etagListMatch(list, etag string) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for list != "" {
+		list = strings.TrimLeft(list, " \t,")
+		tag, rest := scanETag(list)
+		if tag == "" {
+			return false // malformed
+		}
+		if etagMatch(tag, etag, false) {
+			return true
+		}
+		list = rest
+	}
+	return false
+}
+
+// etagMatch compares entity tags a and b using the strong or weak comparison function.
+func etagMatch(a, b string, strong bool) bool {
+	aw := strings.HasPrefix(a, "W/")
+	bw := strings.HasPrefix(b, "W/")
+	if strong && (aw || bw) {
+		return false
+	}
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// scanETag returns the entity tag at the beginning of s, and the rest of s.
+// If s does not begin with a valid entity tag, scanETag returns "", "".
+func scanETag(s string) (tag, rest string) {
+	s = strings.TrimLeft(s, " \t")
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s[start:]) < 2 || s[start] != '"' {
+		return "", ""
+	}
+	for i := start + 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return s[:i+1], s[i+1:]
+		case c == 0x21 || 0x23 <= c && c <= 0x7E || c >= 0x80:
+			// etagc
+		default:
+			return "", ""
+		}
+	}
+	return "", ""
+}
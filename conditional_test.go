@@ -0,0 +1,140 @@
+package hh
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScanETag(t *testing.T) {
+	tests := []struct {
+		in        string
+		tag, rest string
+	}{
+		{`"v1"`, `"v1"`, ``},
+		{`W/"v1"`, `W/"v1"`, ``},
+		{`  "v1", "v2"`, `"v1"`, `, "v2"`},
+		{`""`, `""`, ``},
+		{`"a"b`, `"a"`, `b`},
+		{`v1`, ``, ``},                             // unquoted
+		{`"v1`, ``, ``},                            // unterminated
+		{`W/v1`, ``, ``},                           // weak, unquoted
+		{`w/"v1"`, ``, ``},                         // the weak prefix is case-sensitive
+		{`"v 1"`, ``, ``},                          // space is not an etagc
+		{"\"v\x7f\"", ``, ``},                      // nor is DEL
+		{"\"caf\xc3\xa9\"", "\"caf\xc3\xa9\"", ``}, // obs-text is
+		{`W/`, ``, ``},
+		{``, ``, ``},
+	}
+	for _, tt := range tests {
+		tag, rest := scanETag(tt.in)
+		if tag != tt.tag || rest != tt.rest {
+			t.Errorf("scanETag(%q) = %q, %q; want %q, %q", tt.in, tag, rest, tt.tag, tt.rest)
+		}
+	}
+}
+
+func TestETagListMatch(t *testing.T) {
+	tests := []struct {
+		list, etag string
+		want       bool
+	}{
+		{`"v1"`, `"v1"`, true},
+		{`"v1"`, `"v2"`, false},
+		{`W/"v1"`, `"v1"`, true}, // weak comparison
+		{`"v1"`, `W/"v1"`, true},
+		{`W/"v1"`, `W/"v1"`, true},
+		{`"v0", "v1"`, `"v1"`, true},
+		{`"v0","v1"`, `"v1"`, true},
+		{`"v0" ,	"v1"`, `"v1"`, true},
+		{`"v0", "v2"`, `"v1"`, false},
+		{`"v1",`, `"v1"`, true},
+		{`, "v1"`, `"v1"`, true},
+		{`*`, `"v1"`, true},
+		{` * `, `W/"v1"`, true},
+		{`"v0", *`, `"v1"`, false}, // * must stand alone
+		{`v1`, `"v1"`, false},      // malformed
+		{`"v0", v1, "v1"`, `"v1"`, false},
+		{`"v1`, `"v1"`, false},
+		{`"v1"`, `"V1"`, false}, // opaque tags are case-sensitive
+	}
+	for _, tt := range tests {
+		if got := etagListMatch(tt.list, tt.etag); got != tt.want {
+			t.Errorf("etagListMatch(%q, %q) = %v, want %v", tt.list, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	modtime := time.Date(2024, 3, 1, 12, 0, 0, 500e6, time.UTC) // half a second past
+	date := modtime.Truncate(time.Second).Format(http.TimeFormat)
+	tests := []struct {
+		name    string
+		ifRange string // empty for none
+		etag    string
+		modtime time.Time
+		want    bool
+	}{
+		{"absent", "", `"v1"`, modtime, true},
+		{"strong match", `"v1"`, `"v1"`, modtime, true},
+		{"strong mismatch", `"v2"`, `"v1"`, modtime, false},
+		{"weak in If-Range", `W/"v1"`, `"v1"`, modtime, false},
+		{"weak current", `"v1"`, `W/"v1"`, modtime, false},
+		{"both weak", `W/"v1"`, `W/"v1"`, modtime, false},
+		{"etag unknown", `"v1"`, ``, modtime, false},
+		{"trailing garbage", `"v1" x`, `"v1"`, modtime, false},
+		{"list", `"v1", "v2"`, `"v1"`, modtime, false},
+		{"date truncated to the second", date, `"v1"`, modtime, true},
+		{"date exact", date, ``, modtime.Truncate(time.Second), true},
+		{"date earlier", modtime.Add(-time.Second).Format(http.TimeFormat), `"v1"`, modtime, false},
+		{"date later", modtime.Add(time.Second).Format(http.TimeFormat), `"v1"`, modtime, false},
+		{"date, modtime unknown", date, `"v1"`, time.Time{}, false},
+		{"malformed date", "yesterday", `"v1"`, modtime, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.ifRange != "" {
+				r.Header.Set("If-Range", tt.ifRange)
+			}
+			if got := IfRange(r, tt.etag, tt.modtime); got != tt.want {
+				t.Errorf("IfRange(%q, %q, %v) = %v, want %v", tt.ifRange, tt.etag, tt.modtime, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeBytesIfRange(t *testing.T) {
+	content := []byte("0123456789")
+	modtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		ifRange  string
+		wantCode int
+		wantBody string
+	}{
+		{"no If-Range", "", http.StatusPartialContent, "234"},
+		{"matching date", modtime.Format(http.TimeFormat), http.StatusPartialContent, "234"},
+		{"stale date", modtime.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK, "0123456789"},
+		{"weak etag", `W/"v1"`, http.StatusOK, "0123456789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := func(w http.ResponseWriter, r *http.Request) error {
+				ServeBytes(w, r, content, modtime, "text/plain")
+				return nil
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Range", "bytes=2-4")
+			if tt.ifRange != "" {
+				r.Header.Set("If-Range", tt.ifRange)
+			}
+			rec := httptest.NewRecorder()
+			Wrap(h)(rec, r)
+			if rec.Code != tt.wantCode || rec.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
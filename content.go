@@ -0,0 +1,113 @@
+package hh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// PartialContent returns an HTTPResponseError that responds with a 206 (Partial Content)
+// containing body, which is bytes start through end (inclusive) of a representation total bytes long.
+// The response has a Content-Range header of "bytes start-end/total".
+//
+// If the range is invalid (it is empty, out of bounds, or does not match len(body)),
+// PartialContent returns an error created with fmt.Errorf,
+// which results in a 500 (Internal Server Error), as with ErrorJSON.
+//
+// PartialContent is intended for handlers that hold content in memory
+// and parse the Range header themselves; see also RangeNotSatisfiable.
+// For files and other large content, use http.ServeContent instead.
+func PartialContent(total, start, end int64, body []byte) error {
+	if start < 0 || start > end || end >= total || int64(len(body)) != end-start+1 {
+		return fmt.Errorf("hh.PartialContent: invalid range %d-%d/%d for %d byte body", start, end, total, len(body))
+	}
+	h := make(http.Header)
+	h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	return &response{code: http.StatusPartialContent, header: h, body: body}
+}
+
+// RangeNotSatisfiable returns an HTTPResponseError that responds with a 416 (Range Not Satisfiable)
+// for a representation total bytes long.
+// The response has a Content-Range header of "bytes */total".
+func RangeNotSatisfiable(total int64) error {
+	h := make(http.Header)
+	h.Set("Content-Range", "bytes */"+strconv.FormatInt(total, 10))
+	return textResponse(http.StatusRequestedRangeNotSatisfiable, h)
+}
+
+// ServeBytes replies to r with content, an in-memory representation last modified at modtime,
+// handling conditional and range requests.
+// Depending on the request, it responds with a 200 (OK), 206 (Partial Content),
+// 304 (Not Modified), 412 (Precondition Failed), or 416 (Range Not Satisfiable).
+//
+// ServeBytes is the in-memory analogue of http.ServeContent, which it uses.
+// If contentType is empty, it is detected from content.
+// If modtime is the zero time, no Last-Modified header is sent
+// and If-Modified-Since and If-Unmodified-Since are ignored.
+// To use entity tags, set w's ETag header before calling ServeBytes.
+// If-None-Match uses the weak comparison function, as with CheckIfNoneMatch,
+// and a Range is honored only if any If-Range header matches, as with IfRange;
+// otherwise the full content is sent with a 200.
+func ServeBytes(w http.ResponseWriter, r *http.Request, content []byte, modtime time.Time, contentType string) {
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	http.ServeContent(w, r, "", modtime, bytes.NewReader(content))
+}
+
+// ServeFileStreaming replies to r with the contents of the named file, handling conditional and range requests
+// exactly as http.ServeContent does, but without buffering the file, so that it suits large files.
+//
+// Errors found before the response starts are returned, to be handled as usual:
+// ErrNotFound if the file does not exist or is a directory, a 403 (Forbidden) if it cannot be read,
+// and otherwise an error created with fmt.Errorf, which results in a 500 (Internal Server Error).
+// Once the file is open, the response is sent directly to the client (see WithMaxBufferSize),
+// bypassing response hooks and other processing of buffered responses.
+// From then on, errors cannot be reported to the client; http.ServeContent sends its own error responses,
+// such as 416 (Range Not Satisfiable), and a failure while sending the file truncates the response.
+// The handler must not write anything else before or after calling ServeFileStreaming.
+//
+// A response writer passed to a handler with a time limit (see TimeoutHandler) cannot bypass its buffer;
+// there, the file is buffered as with any other response.
+//
+// name is a file system path. Unlike http.ServeFile, ServeFileStreaming does not examine r's URL;
+// validate any part of name that comes from the request.
+func ServeFileStreaming(w http.ResponseWriter, r *http.Request, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fileError(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fileError(err)
+	}
+	if fi.IsDir() {
+		return ErrNotFound
+	}
+	if bufw := bufferOf(w); bufw != nil && bufw.dst != nil {
+		if bufw.wroteCode || bufw.wroteBody {
+			return fmt.Errorf("hh.ServeFileStreaming: response already written")
+		}
+		w = bufw.bypass()
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+	return nil
+}
+
+// fileError converts an error opening or examining a file into an HTTPResponseError, if appropriate.
+func fileError(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return withResponse(ErrNotFound, err)
+	case errors.Is(err, fs.ErrPermission):
+		return withResponse(Error(http.StatusForbidden), err)
+	}
+	return fmt.Errorf("hh.ServeFileStreaming: %w", err)
+}
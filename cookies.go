@@ -0,0 +1,218 @@
+package hh
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WithCookieDefaults applies security attributes from defaults to cookies lacking them.
+//
+// Just before a response is sent, each Set-Cookie header is inspected.
+// If defaults.Secure or defaults.HttpOnly is set, and the cookie lacks that attribute, it is added.
+// If defaults.SameSite is set (other than http.SameSiteDefaultMode), and the cookie has no SameSite attribute, it is added.
+// Attributes the cookie already has are never changed.
+// Other fields of defaults are ignored.
+//
+// The defaults apply to all responses, including errors that set cookies when rendered.
+// Set-Cookie headers that cannot be parsed are left as is.
+func WithCookieDefaults(defaults http.Cookie) Option {
+	return func(wr *Wrapper) {
+		wr.cookieDefaults = &defaults
+	}
+}
+
+// applyCookieDefaults implements WithCookieDefaults.
+func applyCookieDefaults(h http.Header, defaults *http.Cookie) {
+	for i, v := range h["Set-Cookie"] {
+		c, err := http.ParseSetCookie(v)
+		if err != nil {
+			continue
+		}
+		var b strings.Builder
+		b.WriteString(v)
+		if defaults.Secure && !c.Secure {
+			b.WriteString("; Secure")
+		}
+		if defaults.HttpOnly && !c.HttpOnly {
+			b.WriteString("; HttpOnly")
+		}
+		if c.SameSite == 0 {
+			switch defaults.SameSite {
+			case http.SameSiteLaxMode:
+				b.WriteString("; SameSite=Lax")
+			case http.SameSiteStrictMode:
+				b.WriteString("; SameSite=Strict")
+			case http.SameSiteNoneMode:
+				b.WriteString("; SameSite=None")
+			}
+		}
+		h["Set-Cookie"][i] = b.String()
+	}
+}
+
+// RedirectWithCookies returns an HTTPResponseError that redirects to location with status statusCode,
+// setting cookies on the response, as is common for a redirect after a POST
+// that sets a "flash" message.
+// The redirect is rendered using http.Redirect.
+//
+// If statusCode is not a 3xx status code, or any of the cookies is invalid,
+// RedirectWithCookies returns an error created with fmt.Errorf,
+// which results in a 500 (Internal Server Error), as with ErrorJSON.
+func RedirectWithCookies(statusCode int, location string, cookies ...*http.Cookie) error {
+	if statusCode < 300 || statusCode > 399 {
+		return fmt.Errorf("hh.RedirectWithCookies: status code %d is not a redirect", statusCode)
+	}
+	for _, c := range cookies {
+		if err := c.Valid(); err != nil {
+			return fmt.Errorf("hh.RedirectWithCookies: %w", err)
+		}
+	}
+	return &redirect{code: statusCode, location: location, cookies: cookies}
+}
+
+type redirect struct {
+	code     int
+	location string
+	cookies  []*http.Cookie
+}
+
+var _ HTTPRequestRenderer = (*redirect)(nil)
+
+func (e *redirect) Error() string {
+	return fmt.Sprintf("%d: redirect to %s", e.code, e.location)
+}
+
+func (e *redirect) RenderHTTP(w http.ResponseWriter) {
+	for _, c := range e.cookies {
+		http.SetCookie(w, c)
+	}
+	w.Header().Set("Location", e.location)
+	w.WriteHeader(e.code)
+}
+
+func (e *redirect) RenderHTTPRequest(w http.ResponseWriter, r *http.Request) {
+	for _, c := range e.cookies {
+		http.SetCookie(w, c)
+	}
+	http.Redirect(w, r, e.location, e.code)
+}
+
+// A CookieSet collects the cookies to set and delete in a response.
+// Create one with Cookies.
+//
+// Unlike http.SetCookie, which adds a Set-Cookie header immediately,
+// a CookieSet sends nothing until the response is sent, and then sends
+// a single Set-Cookie header for each cookie, identified by its name, path, and domain.
+// A later operation on a cookie replaces any earlier one,
+// so that, for example, deleting an old session cookie and then setting a new one
+// with the same name sends only the new one.
+// Set-Cookie headers are sent in the order in which their cookies were first set or deleted.
+//
+// The cookies are part of the handler's response:
+// like headers set on its http.ResponseWriter, they are discarded if the handler fails.
+// They are added when the response is sent, so response hooks do not see them,
+// and WithResponseCache does not store responses that set any.
+// Cookies set or deleted after the response has begun to be sent,
+// for example because of WithMaxBufferSize, cannot be sent,
+// and result in ErrHeadersAfterBody.
+type CookieSet struct {
+	w       http.ResponseWriter
+	bufw    *bufferingResponseWriter // nil if w was not created by Wrap; see Cookies
+	cookies []*http.Cookie
+}
+
+// Cookies returns the CookieSet for the response being written to w,
+// a response writer passed to a wrapped handler.
+// Repeated calls with the same w return the same CookieSet.
+//
+// If w was not created by Wrap, and does not wrap such a writer via an Unwrap method,
+// nothing is buffered: the returned CookieSet adds a Set-Cookie header to w immediately,
+// as http.SetCookie does, and does not deduplicate operations.
+func Cookies(w http.ResponseWriter) *CookieSet {
+	bufw := bufferOf(w)
+	if bufw == nil {
+		return &CookieSet{w: w}
+	}
+	if bufw.cookies == nil {
+		bufw.cookies = &CookieSet{w: w, bufw: bufw}
+	}
+	return bufw.cookies
+}
+
+// Set sets c on the response, replacing any earlier operation on the same cookie.
+// An invalid cookie (see http.Cookie.Valid) results in a 500 (Internal Server Error),
+// as with other errors writing the response.
+func (s *CookieSet) Set(c *http.Cookie) {
+	if err := c.Valid(); err != nil {
+		s.fail(fmt.Errorf("hh.CookieSet.Set: %w", err))
+		return
+	}
+	c2 := *c
+	s.add(&c2)
+}
+
+// Delete deletes the cookie with c's name, path, and domain,
+// by setting an expired cookie, with an empty value, in its place.
+// The expired cookie has c's other attributes, such as Secure and SameSite,
+// which some browsers require to match those of the cookie being deleted;
+// c's Value, Expires, and MaxAge are ignored.
+// Delete replaces any earlier operation on the same cookie.
+func (s *CookieSet) Delete(c *http.Cookie) {
+	c2 := *c
+	c2.Value = ""
+	c2.Quoted = false
+	c2.MaxAge = -1 // Max-Age=0
+	c2.Expires = time.Unix(0, 0)
+	if err := c2.Valid(); err != nil {
+		s.fail(fmt.Errorf("hh.CookieSet.Delete: %w", err))
+		return
+	}
+	s.add(&c2)
+}
+
+// add records c, replacing any earlier operation on the same cookie.
+func (s *CookieSet) add(c *http.Cookie) {
+	if s.bufw == nil {
+		http.SetCookie(s.w, c)
+		return
+	}
+	if s.bufw.passThrough {
+		s.bufw.setError(ErrHeadersAfterBody)
+		return
+	}
+	for i, old := range s.cookies {
+		if sameCookie(old, c) {
+			s.cookies[i] = c
+			return
+		}
+	}
+	s.cookies = append(s.cookies, c)
+}
+
+// fail records err as an error writing the response.
+func (s *CookieSet) fail(err error) {
+	if s.bufw == nil {
+		// Nothing to record it in; drop the cookie, as http.SetCookie would.
+		return
+	}
+	s.bufw.setError(err)
+}
+
+// sameCookie reports whether a and b identify the same cookie,
+// in which case a Set-Cookie header for one replaces the other.
+func sameCookie(a, b *http.Cookie) bool {
+	return a.Name == b.Name &&
+		strings.EqualFold(strings.TrimPrefix(a.Domain, "."), strings.TrimPrefix(b.Domain, ".")) &&
+		a.Path == b.Path
+}
+
+// apply adds a Set-Cookie header for each cookie in s to h.
+func (s *CookieSet) apply(h http.Header) {
+	for _, c := range s.cookies {
+		if v := c.String(); v != "" {
+			h.Add("Set-Cookie", v)
+		}
+	}
+}
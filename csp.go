@@ -0,0 +1,87 @@
+package hh
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+)
+
+// WithContentSecurityPolicy sets a Content-Security-Policy header to policy,
+// and a Content-Security-Policy-Report-Only header to reportOnly, on every response,
+// including error responses. An empty policy omits the corresponding header.
+// The headers are defaults, as with WithHeaders.
+//
+// Setting both is the usual way to roll out a policy change:
+// the browser enforces policy, and reports what reportOnly would have blocked,
+// without blocking it. Use CSPReports to receive the reports.
+func WithContentSecurityPolicy(policy, reportOnly string) Option {
+	h := make(http.Header)
+	if policy != "" {
+		h.Set("Content-Security-Policy", policy)
+	}
+	if reportOnly != "" {
+		h.Set("Content-Security-Policy-Report-Only", reportOnly)
+	}
+	return WithHeaders(h)
+}
+
+// maxCSPReport is the largest request body accepted by CSPReports.
+const maxCSPReport = 64 << 10
+
+// CSPReports returns a HandlerFunc that receives Content Security Policy violation reports
+// and logs each one to logger, at level slog.LevelWarn, responding with 204 (No Content).
+// If logger is nil, slog.Default() is used.
+// Use it as the target of a policy's report-uri or report-to directive.
+//
+// It accepts POST requests whose body is either a report in the original format,
+// with Content-Type application/csp-report, or an array of reports in the Reporting API format,
+// with Content-Type application/reports+json.
+// Other methods result in ErrMethodNotAllowed, other content types in ErrUnsupportedMediaType,
+// bodies larger than 64 KiB in a 413 (Request Entity Too Large),
+// and malformed bodies in a 400 (Bad Request).
+func CSPReports(logger *slog.Logger) HandlerFunc {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodPost {
+			ResponseHeader(r).Set("Allow", http.MethodPost)
+			return ErrMethodNotAllowed
+		}
+		mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		body := http.MaxBytesReader(w, r.Body, maxCSPReport)
+		var reports []map[string]any
+		switch mt {
+		case "application/csp-report":
+			var report struct {
+				Report map[string]any `json:"csp-report"`
+			}
+			if err := decodeJSON(body, &report); err != nil {
+				return err
+			}
+			if report.Report != nil {
+				reports = append(reports, report.Report)
+			}
+		case "application/reports+json":
+			var batch []struct {
+				Type string         `json:"type"`
+				Body map[string]any `json:"body"`
+			}
+			if err := decodeJSON(body, &batch); err != nil {
+				return err
+			}
+			for _, report := range batch {
+				if report.Type == "csp-violation" && report.Body != nil {
+					reports = append(reports, report.Body)
+				}
+			}
+		default:
+			return ErrUnsupportedMediaType
+		}
+		for _, report := range reports {
+			logger.WarnContext(r.Context(), "hh: content security policy violation", "report", report)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
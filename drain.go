@@ -0,0 +1,106 @@
+package hh
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// A Drainer coordinates graceful shutdown of wrapped handlers.
+// Use it with WithDrain. The zero value is ready to use.
+//
+// A typical shutdown sequence is:
+//
+//	drainer.Drain()
+//	drainer.Wait(ctx)
+//	srv.Shutdown(ctx)
+type Drainer struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	idle     chan struct{} // closed when inFlight reaches 0; see Wait
+}
+
+// WithDrain registers requests with d.
+// Once d.Drain has been called, new requests are rejected with a 503 (Service Unavailable)
+// with a Retry-After header of 1 second and a Connection header of close,
+// handled as if the handler had returned it, so that errorware and other options apply.
+// Requests that are already in flight run to completion.
+// A request is in flight until its response has been written.
+func WithDrain(d *Drainer) Option {
+	return func(wr *Wrapper) {
+		wr.drainer = d
+	}
+}
+
+// Drain causes subsequent requests to be rejected. It does not wait; see Wait.
+func (d *Drainer) Drain() {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+}
+
+// Draining reports whether Drain has been called.
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// InFlight returns the number of requests currently in flight.
+func (d *Drainer) InFlight() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inFlight
+}
+
+// Wait waits until no requests are in flight or ctx is done.
+// It returns ctx.Err() if ctx is done first.
+// If Drain has not been called, new requests may arrive at any time,
+// so Wait is normally called after Drain.
+func (d *Drainer) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	if d.inFlight == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	if d.idle == nil {
+		d.idle = make(chan struct{})
+	}
+	idle := d.idle
+	d.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enter registers a new request, reporting whether it may proceed.
+// If enter returns true, the caller must call exit when the request is done.
+func (d *Drainer) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inFlight++
+	return true
+}
+
+// exit unregisters a request.
+func (d *Drainer) exit() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.inFlight == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// rejectDraining is the handler for requests rejected by WithDrain.
+func rejectDraining(w http.ResponseWriter, r *http.Request) error {
+	return textResponse(http.StatusServiceUnavailable, http.Header{"Retry-After": {"1"}, "Connection": {"close"}})
+}
@@ -0,0 +1,292 @@
+package hh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHandled, returned by errorware, tells Wrap that the error has been fully handled,
+// and that Wrap should send nothing: neither the handler's buffered response nor an error response.
+// It suits errorware that has dealt with the response some other way,
+// or that knows there is no one to send it to, such as when the client has gone away.
+// If nothing else has written a response, net/http sends an empty 200 (OK).
+// Errorware may wrap ErrHandled, to keep the original error for logging; Wrap checks using errors.Is.
+// Unlike nil, which resolves the error, ErrHandled never causes the buffered response to be sent.
+var ErrHandled = errors.New("hh: error handled by errorware")
+
+// defaultErrorware is the errorware set by SetDefaultErrorware.
+var defaultErrorware atomic.Pointer[[]func(*http.Request, error) error]
+
+// SetDefaultErrorware sets errorware that applies to every wrapped handler,
+// whether created by a package-level function such as Wrap or by a Wrapper,
+// replacing any set by a previous call.
+// For each request, the default errorware runs first, in order,
+// followed by the errorware passed when wrapping the handler.
+// This makes it suitable for cross-cutting concerns, such as error reporting,
+// that are easy to forget when wrapping each handler.
+//
+// The default errorware is looked up when each request is handled,
+// so it applies to handlers wrapped before SetDefaultErrorware is called.
+// SetDefaultErrorware is intended to be called once, during initialization, before serving.
+// Calling it while serving is safe, but requests in progress may or may not see the change.
+func SetDefaultErrorware(errorware ...func(*http.Request, error) error) {
+	errorware = slices.Clone(errorware)
+	defaultErrorware.Store(&errorware)
+}
+
+// WithErrorwareGuard reports errorware that drops an HTTPResponseError.
+//
+// An errorware that returns a brand-new error instead of wrapping the one it was given
+// silently converts a carefully chosen response (a 404, say) into a generic 500.
+// With this option, whenever an errorware receives an error that resolves to
+// an HTTPResponseError and returns a non-nil error that does not,
+// a warning identifying the errorware is logged to logger.
+// If logger is nil, slog.Default() is used.
+// logger is also used to report responses rendered more than once,
+// which are otherwise reported to slog.Default().
+//
+// The check costs an extra error chain walk per errorware.
+// It is intended for development.
+func WithErrorwareGuard(logger *slog.Logger) Option {
+	return func(wr *Wrapper) {
+		if logger == nil {
+			logger = slog.Default()
+		}
+		wr.guard = logger
+	}
+}
+
+// logger returns the logger for reporting misuse:
+// the logger set by WithErrorwareGuard, or slog.Default().
+func (wr *Wrapper) logger() *slog.Logger {
+	if wr.guard != nil {
+		return wr.guard
+	}
+	return slog.Default()
+}
+
+// checkErrorware implements WithErrorwareGuard.
+// The ith errorware, fn, converted prev into err.
+func (wr *Wrapper) checkErrorware(r *http.Request, i int, fn func(*http.Request, error) error, prev, err error) {
+	if prev == nil || err == nil {
+		return
+	}
+	if asHTTPResponseError(prev) == nil || asHTTPResponseError(err) != nil {
+		return
+	}
+	wr.guard.WarnContext(r.Context(), "hh: errorware dropped HTTPResponseError; response will be a 500",
+		"errorware", funcName(fn), "index", i, "before", prev, "after", err)
+}
+
+// funcName returns the name of the function fn.
+func funcName(fn any) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	return f.Name()
+}
+
+// WithFallbackRenderer sets fn to render errors that do not resolve to an HTTPResponseError,
+// in place of the plain text 500 (Internal Server Error) that Wrap sends by default.
+// This allows a consistent error format, such as a JSON envelope with a request ID, for unrecognized errors.
+// fn is called with the response writer, the request, and the error, after errorware;
+// it is never called for errors that resolve to an HTTPResponseError.
+// fn should write a response with a 5xx status code. If it writes nothing, the default response is sent.
+func WithFallbackRenderer(fn func(w http.ResponseWriter, r *http.Request, err error)) Option {
+	return func(wr *Wrapper) {
+		wr.fallbackRender = fn
+	}
+}
+
+// WithEmptyOnResolve changes the response sent when errorware resolves an error by returning nil.
+//
+// By default, when errorware converts a non-nil error to nil,
+// whatever the handler wrote before returning its error is sent, exactly as if it had succeeded.
+// That output is often partial, since the handler was failing.
+// With this option, the handler's output, including its headers, is discarded,
+// and the response is an empty 200 (OK) instead.
+// Responses to requests whose handler returned nil are unaffected.
+func WithEmptyOnResolve() Option {
+	return func(wr *Wrapper) {
+		wr.emptyOnResolve = true
+	}
+}
+
+// MapDeadline returns errorware that converts errors caused by an expired context deadline
+// (as reported by errors.Is(err, context.DeadlineExceeded)) into ErrGatewayTimeout.
+// The original error remains in the chain, for logging and for errors.Is and errors.As.
+// Errors that already resolve to an HTTPResponseError are left unchanged.
+func MapDeadline() func(*http.Request, error) error {
+	return func(r *http.Request, err error) error {
+		if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		return withResponse(ErrGatewayTimeout, err)
+	}
+}
+
+// withResponse returns an error that renders as re, wrapping cause.
+// If cause already resolves to an HTTPResponseError, it is returned unchanged.
+func withResponse(re, cause error) error {
+	if asHTTPResponseError(cause) != nil {
+		return cause
+	}
+	return fmt.Errorf("%w: %w", re, cause)
+}
+
+// stdErrorStatuses is the mapping used by MapStdErrors, in order.
+var stdErrorStatuses = []struct {
+	match func(error) bool
+	code  int
+}{
+	{isAs[*http.MaxBytesError], http.StatusRequestEntityTooLarge},
+	{isErr(io.ErrUnexpectedEOF), http.StatusBadRequest},
+	{isAs[*json.SyntaxError], http.StatusBadRequest},
+	{isAs[*json.UnmarshalTypeError], http.StatusBadRequest},
+	{isAs[*strconv.NumError], http.StatusBadRequest},
+	{isAs[*time.ParseError], http.StatusBadRequest},
+	{isErr(fs.ErrNotExist), http.StatusNotFound},
+	{isErr(fs.ErrPermission), http.StatusForbidden},
+	{isErr(fs.ErrExist), http.StatusConflict},
+	{isErr(errors.ErrUnsupported), http.StatusNotImplemented},
+	{isErr(context.DeadlineExceeded), http.StatusGatewayTimeout},
+	{isErr(os.ErrDeadlineExceeded), http.StatusGatewayTimeout},
+}
+
+func isErr(target error) func(error) bool {
+	return func(err error) bool { return errors.Is(err, target) }
+}
+
+func isAs[T error](err error) bool {
+	var t T
+	return errors.As(err, &t)
+}
+
+// MapStdErrors returns errorware that converts common standard library errors into HTTP errors
+// with the corresponding status code and its default status text.
+// The first matching entry in this table, checked using errors.Is or errors.As, is used:
+//
+//	*http.MaxBytesError         413 Request Entity Too Large
+//	io.ErrUnexpectedEOF         400 Bad Request (typically a truncated request body)
+//	*json.SyntaxError           400 Bad Request
+//	*json.UnmarshalTypeError    400 Bad Request
+//	*strconv.NumError           400 Bad Request
+//	*time.ParseError            400 Bad Request
+//	fs.ErrNotExist              404 Not Found
+//	fs.ErrPermission            403 Forbidden
+//	fs.ErrExist                 409 Conflict
+//	errors.ErrUnsupported       501 Not Implemented
+//	context.DeadlineExceeded    504 Gateway Timeout
+//	os.ErrDeadlineExceeded      504 Gateway Timeout
+//
+// The table assumes that parse and decode errors arise from client input.
+// If a handler can fail with these errors for other reasons, such as decoding an upstream response,
+// it should wrap them in an HTTPResponseError itself.
+//
+// The original error remains in the chain.
+// Errors that already resolve to an HTTPResponseError are left unchanged,
+// so custom mappings placed earlier in the errorware chain take precedence.
+func MapStdErrors() func(*http.Request, error) error {
+	return func(r *http.Request, err error) error {
+		if err == nil {
+			return nil
+		}
+		for _, m := range stdErrorStatuses {
+			if m.match(err) {
+				return withResponse(Error(m.code), err)
+			}
+		}
+		return err
+	}
+}
+
+// MapHTTPStatus returns errorware that converts errors with an HTTPStatus method,
+// a lightweight alternative to implementing HTTPResponseError:
+//
+//	func (e *OutOfStockError) HTTPStatus() int { return http.StatusConflict }
+//
+// The first error in the chain, as found by errors.As, with an HTTPStatus() int method
+// renders as a response with that status and its Error text as a plain text body.
+// Since the text is sent to the client, use it only with errors whose messages are safe to reveal.
+// Statuses outside the range 400-599 are ignored.
+//
+// The original error remains in the chain.
+// Errors that already resolve to an HTTPResponseError are left unchanged.
+func MapHTTPStatus() func(*http.Request, error) error {
+	return func(r *http.Request, err error) error {
+		var se interface {
+			error
+			HTTPStatus() int
+		}
+		if err == nil || !errors.As(err, &se) {
+			return err
+		}
+		code := se.HTTPStatus()
+		if code < 400 || code > 599 {
+			return err
+		}
+		return withResponse(ErrorText(code, se.Error()), err)
+	}
+}
+
+// LogErrors returns errorware that logs each error to logger, and returns it unchanged.
+// If logger is nil, slog.Default() is used.
+//
+// The log entry includes the request's method and path, its route name (see WithRouteName)
+// and ID (see WithRequestID), if any, and the status code with which the error would render
+// if it reached Wrap unchanged: 500 (Internal Server Error) for errors that are not HTTPResponseErrors.
+// Errors with a 4xx status are logged at slog.LevelWarn, and all others at slog.LevelError.
+// Errors that render with a status below 400, such as a Reply or a redirect, are not failures,
+// and are not logged.
+//
+// Place LogErrors after errorware that converts errors to HTTPResponseErrors,
+// such as MapStdErrors, so that it logs the status actually sent.
+func LogErrors(logger *slog.Logger) func(*http.Request, error) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(r *http.Request, err error) error {
+		if err == nil {
+			return nil
+		}
+		code := http.StatusInternalServerError
+		if re, ok := AsHTTPResponseError(err); ok {
+			code = statusCodeOf(re)
+		}
+		if code < 400 {
+			return err
+		}
+		level := slog.LevelError
+		if code >= 400 && code <= 499 {
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", code),
+			slog.Any("error", err),
+		}
+		if route := RouteName(r); route != "" {
+			attrs = append(attrs, slog.String("route", route))
+		}
+		if id := RequestID(r); id != "" {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		logger.LogAttrs(r.Context(), level, "hh: request failed", attrs...)
+		return err
+	}
+}
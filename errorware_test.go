@@ -0,0 +1,163 @@
+package hh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDefaultErrorware(t *testing.T) {
+	var calls []string
+	var seen error
+	SetDefaultErrorware(func(r *http.Request, err error) error {
+		calls = append(calls, "default")
+		seen = err
+		return err
+	})
+	t.Cleanup(func() { SetDefaultErrorware() })
+
+	failure := errors.New("failure")
+	h := func(w http.ResponseWriter, r *http.Request) error { return failure }
+
+	// No errorware passed to Wrap.
+	rec := httptest.NewRecorder()
+	Wrap(h)(rec, httptest.NewRequest("GET", "/", nil))
+	if seen != failure {
+		t.Errorf("default errorware saw %v, want %v", seen, failure)
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	// Defaults run before errorware passed to Wrap.
+	calls = nil
+	perCall := func(r *http.Request, err error) error {
+		calls = append(calls, "per-call")
+		return err
+	}
+	NewWrapper().Wrap(h, perCall)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if want := []string{"default", "per-call"}; !slices.Equal(calls, want) {
+		t.Errorf("errorware ran in order %q, want %q", calls, want)
+	}
+}
+
+// recordingResponseWriter is an http.ResponseWriter that records the calls made to it.
+type recordingResponseWriter struct {
+	header       http.Header
+	writeHeaders []int
+	body         []byte
+}
+
+func (w *recordingResponseWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *recordingResponseWriter) WriteHeader(code int) {
+	w.writeHeaders = append(w.writeHeaders, code)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	w.body = append(w.body, b...)
+	return len(b), nil
+}
+
+func TestErrHandled(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("X-Handler", "1")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("buffered"))
+		return errors.New("client went away")
+	}
+
+	t.Run("ErrHandled", func(t *testing.T) {
+		handled := func(r *http.Request, err error) error {
+			return fmt.Errorf("%w: %w", ErrHandled, err)
+		}
+		w := new(recordingResponseWriter)
+		Wrap(h, handled)(w, httptest.NewRequest("GET", "/", nil))
+		if len(w.writeHeaders) != 0 || len(w.body) != 0 || len(w.header) != 0 {
+			t.Errorf("sent WriteHeader %v, header %v, body %q; want nothing", w.writeHeaders, w.header, w.body)
+		}
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		resolve := func(r *http.Request, err error) error { return nil }
+		w := new(recordingResponseWriter)
+		Wrap(h, resolve)(w, httptest.NewRequest("GET", "/", nil))
+		if !slices.Equal(w.writeHeaders, []int{http.StatusAccepted}) || string(w.body) != "buffered" || w.header.Get("X-Handler") != "1" {
+			t.Errorf("sent WriteHeader %v, header %v, body %q; want the buffered 202 response", w.writeHeaders, w.header, w.body)
+		}
+	})
+}
+
+func TestFallbackRenderer(t *testing.T) {
+	var calls int
+	wr := NewWrapper(WithFallbackRenderer(func(w http.ResponseWriter, r *http.Request, err error) {
+		calls++
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal"})
+	}))
+	tests := []struct {
+		name      string
+		err       error
+		wantCode  int
+		wantBody  string
+		wantCalls int
+	}{
+		{"plain error", errors.New("boom"), http.StatusInternalServerError, `{"error":"internal"}`, 1},
+		{"wrapped plain error", fmt.Errorf("context: %w", errors.New("boom")), http.StatusInternalServerError, `{"error":"internal"}`, 1},
+		{"HTTPResponseError", ErrNotFound, http.StatusNotFound, "Not Found\n", 0},
+		{"wrapped HTTPResponseError", fmt.Errorf("lookup: %w", ErrNotFound), http.StatusNotFound, "Not Found\n", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			rec := httptest.NewRecorder()
+			wr.Wrap(func(w http.ResponseWriter, r *http.Request) error { return tt.err })(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != tt.wantCode || rec.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("fallback called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestLogErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string // the logged level, or "" for no log entry
+	}{
+		{"Reply", OK().JSON(map[string]int{"n": 1}), ""},
+		{"Reply 201", Respond(http.StatusCreated).JSON(map[string]int{"n": 1}), ""},
+		{"redirect", RedirectWithCookies(http.StatusSeeOther, "/next"), ""},
+		{"4xx", ErrNotFound, "WARN"},
+		{"5xx", Error(http.StatusBadGateway), "ERROR"},
+		{"plain", errors.New("boom"), "ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			h := func(w http.ResponseWriter, r *http.Request) error { return tt.err }
+			Wrap(h, LogErrors(logger))(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			got := buf.String()
+			switch {
+			case tt.want == "" && got != "":
+				t.Errorf("logged %q, want nothing", got)
+			case tt.want != "" && !strings.Contains(got, "level="+tt.want):
+				t.Errorf("logged %q, want level %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package hh
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+)
+
+// WithExpvar publishes request metrics using package expvar, under names beginning with prefix:
+//
+//	<prefix>.requests   total requests
+//	<prefix>.responses  responses by status class ("2xx", "4xx", and so on), as a map
+//	<prefix>.errors     requests for which the error pipeline produced a non-nil error
+//	<prefix>.panics     handler panics, whether recovered (see PanicError) or not
+//
+// Status classes use the status code actually sent, after errorware.
+// Wrappers created with the same prefix share counters,
+// so WithExpvar may safely be used for many routes.
+func WithExpvar(prefix string) Option {
+	return func(wr *Wrapper) {
+		wr.metrics = expvarMetricsFor(prefix)
+	}
+}
+
+// expvarMetrics are the counters published by WithExpvar.
+type expvarMetrics struct {
+	requests  *expvar.Int
+	responses *expvar.Map
+	errors    *expvar.Int
+	panics    *expvar.Int
+}
+
+var (
+	expvarMu       sync.Mutex
+	expvarByPrefix = make(map[string]*expvarMetrics) // prefix to metrics
+)
+
+// expvarMetricsFor returns the metrics for prefix, publishing them if necessary.
+func expvarMetricsFor(prefix string) *expvarMetrics {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if m, ok := expvarByPrefix[prefix]; ok {
+		return m
+	}
+	m := &expvarMetrics{
+		requests:  publishInt(prefix + ".requests"),
+		responses: publishMap(prefix + ".responses"),
+		errors:    publishInt(prefix + ".errors"),
+		panics:    publishInt(prefix + ".panics"),
+	}
+	expvarByPrefix[prefix] = m
+	return m
+}
+
+// publishInt returns the expvar.Int named name, publishing a new one if necessary.
+// If name is already published as something other than an *expvar.Int,
+// the returned counter is unpublished.
+func publishInt(name string) *expvar.Int {
+	switch v := expvar.Get(name).(type) {
+	case nil:
+		return expvar.NewInt(name)
+	case *expvar.Int:
+		return v
+	}
+	return new(expvar.Int)
+}
+
+// publishMap is like publishInt, for an expvar.Map.
+func publishMap(name string) *expvar.Map {
+	switch v := expvar.Get(name).(type) {
+	case nil:
+		return expvar.NewMap(name)
+	case *expvar.Map:
+		return v
+	}
+	return new(expvar.Map).Init()
+}
+
+// record records the outcome of a request.
+func (m *expvarMetrics) record(code int, err error) {
+	m.responses.Add(strconv.Itoa(code/100)+"xx", 1)
+	if err != nil {
+		m.errors.Add(1)
+	}
+}
+
+// countPanic counts a panic in progress, if any, and continues it.
+// It must be deferred directly.
+func (m *expvarMetrics) countPanic() {
+	if p := recover(); p != nil {
+		m.panics.Add(1)
+		panic(p)
+	}
+}
@@ -0,0 +1,41 @@
+package hh
+
+import (
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// WithWriterGuard reports handlers that bypass Wrap's buffering.
+//
+// A wrapped handler must write only to the http.ResponseWriter that Wrap passes it.
+// Code that reaches the underlying writer some other way,
+// such as through a closure or a context value set by outer middleware,
+// defeats Wrap's guarantee that a failed handler leaves no trace in the response.
+// With this option, Wrap records the underlying writer's header before calling the handler,
+// and after the handler returns, before writing the response,
+// logs a warning to logger if the header has changed.
+// If logger is nil, slog.Default() is used.
+//
+// Writes to the underlying writer's body that leave its header unchanged cannot be detected;
+// net/http typically reports them as superfluous WriteHeader calls when the response is written.
+// The check copies the header for every request. It is intended for development.
+func WithWriterGuard(logger *slog.Logger) Option {
+	return func(wr *Wrapper) {
+		if logger == nil {
+			logger = slog.Default()
+		}
+		wr.writerGuard = logger
+	}
+}
+
+// checkWriter implements WithWriterGuard.
+// before is a copy of w's header from before the handler was called.
+func (wr *Wrapper) checkWriter(w http.ResponseWriter, r *http.Request, before http.Header) {
+	if maps.EqualFunc(before, w.Header(), slices.Equal) {
+		return
+	}
+	wr.writerGuard.WarnContext(r.Context(), "hh: handler modified the underlying ResponseWriter directly, bypassing buffering",
+		"route", RouteName(r), "method", r.Method, "path", r.URL.Path)
+}
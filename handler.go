@@ -0,0 +1,45 @@
+package hh
+
+import "net/http"
+
+// A WrappedHandler is an http.Handler that serves requests using a HandlerFunc,
+// exactly as with Wrap. Create one using WrapHandler.
+type WrappedHandler struct {
+	wr        *Wrapper
+	h         HandlerFunc
+	errorware []func(*http.Request, error) error
+	serve     http.HandlerFunc
+}
+
+// WrapHandler is like Wrap, but returns a *WrappedHandler,
+// for use with routers and middleware that expect an http.Handler.
+func WrapHandler(h HandlerFunc, errorware ...func(*http.Request, error) error) *WrappedHandler {
+	return defaultWrapper.WrapHandler(h, errorware...)
+}
+
+// WrapHandler is like the package-level WrapHandler, using wr to wrap h.
+func (wr *Wrapper) WrapHandler(h HandlerFunc, errorware ...func(*http.Request, error) error) *WrappedHandler {
+	return &WrappedHandler{
+		wr:        wr,
+		h:         h,
+		errorware: errorware,
+		serve:     wr.Wrap(h, errorware...),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (wh *WrappedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wh.serve(w, r)
+}
+
+// Append returns a new WrappedHandler for the same HandlerFunc,
+// which applies errorware after wh's errorware; wh is unchanged.
+// This composes errorware shared by a group of routes with errorware for a single route.
+//
+// Append is equivalent to calling WrapHandler again with the combined errorware.
+// In particular, the new handler does not share wh's concurrency limit (see WithConcurrencyLimit)
+// or in-flight requests (see WithSingleFlight).
+func (wh *WrappedHandler) Append(errorware ...func(*http.Request, error) error) *WrappedHandler {
+	all := append(wh.errorware[:len(wh.errorware):len(wh.errorware)], errorware...)
+	return wh.wr.WrapHandler(wh.h, all...)
+}
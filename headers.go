@@ -0,0 +1,138 @@
+package hh
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AddLink adds a link to uri with relation type rel to w's Link header, as specified by RFC 8288.
+// Multiple links are combined into a single comma-separated header value.
+// For example, after
+//
+//	hh.AddLink(w, "/things?page=3", "next")
+//	hh.AddLink(w, "/things?page=1", "prev")
+//
+// the Link header is
+//
+//	</things?page=3>; rel="next", </things?page=1>; rel="prev"
+//
+// Characters that are not permitted in a URI, such as spaces and angle brackets, are percent-encoded.
+func AddLink(w http.ResponseWriter, uri, rel string) {
+	h := w.Header()
+	link := formatLink(uri, rel)
+	if prev := h.Values("Link"); len(prev) > 0 {
+		link = strings.Join(prev, ", ") + ", " + link
+	}
+	h.Set("Link", link)
+}
+
+// formatLink formats a single Link header link-value.
+func formatLink(uri, rel string) string {
+	var b strings.Builder
+	b.WriteByte('<')
+	const hex = "0123456789ABCDEF"
+	for i := 0; i < len(uri); i++ {
+		c := uri[i]
+		if c <= ' ' || c >= 0x7f || c == '<' || c == '>' || c == '"' {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	b.WriteString(`>; rel=`)
+	b.WriteString(quoteString(rel))
+	return b.String()
+}
+
+// quoteString returns s as an HTTP quoted-string.
+// Control characters, which cannot be represented, are removed.
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c < ' ' && c != '\t', c == 0x7f:
+			continue
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// SetAge sets w's Age header to d, the time since the response was generated or validated
+// by the origin server, in whole seconds, as specified by RFC 9111.
+// Negative durations are treated as zero.
+// SetAge does not modify the Cache-Control header; a cache serving a stored response
+// should replay the Cache-Control header that was stored with it.
+func SetAge(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Age", strconv.FormatInt(int64(max(d, 0)/time.Second), 10))
+}
+
+// WithHeaders sets default response headers, such as a cache policy, for every response,
+// including error responses.
+// A header is added only if the response does not already have a value for it,
+// so headers set by the handler or by an error's renderer take precedence.
+// Header names in h should be in canonical form, as produced by http.Header.Set.
+// Multiple uses of WithHeaders accumulate; for a header set more than once, the last use wins.
+func WithHeaders(h http.Header) Option {
+	return func(wr *Wrapper) {
+		if wr.headers == nil {
+			wr.headers = make(http.Header)
+		}
+		for k, v := range h {
+			wr.headers[k] = slices.Clone(v)
+		}
+	}
+}
+
+// WithDeprecation marks every response, including error responses, as coming from a deprecated endpoint,
+// with the header "Deprecation: true" and, if sunset is not the zero time,
+// a Sunset header (RFC 8594) giving the time after which the endpoint may stop responding.
+// The headers are defaults, as with WithHeaders.
+//
+// Deprecation usually applies to a single route; see Wrapper.With.
+func WithDeprecation(sunset time.Time) Option {
+	h := http.Header{"Deprecation": {"true"}}
+	if !sunset.IsZero() {
+		h.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+	}
+	return WithHeaders(h)
+}
+
+// clearSiteDataTypes are the known Clear-Site-Data types.
+var clearSiteDataTypes = []string{"cache", "clientHints", "cookies", "executionContexts", "prefetchCache", "prerenderCache", "storage", "*"}
+
+// ClearSiteData sets w's Clear-Site-Data header, which asks the browser to clear
+// the given types of data for the site, such as "cookies" and "storage", or "*" for all of them.
+// It is typically used in the response to a logout request.
+// The types are quoted as the header requires.
+// If types is empty, ClearSiteData uses "*".
+//
+// If any type is not one of "cache", "clientHints", "cookies", "executionContexts",
+// "prefetchCache", "prerenderCache", "storage", or "*",
+// ClearSiteData leaves the header unchanged and returns an error created with fmt.Errorf,
+// which results in a 500 (Internal Server Error) when returned from a wrapped handler.
+func ClearSiteData(w http.ResponseWriter, types ...string) error {
+	if len(types) == 0 {
+		types = []string{"*"}
+	}
+	quoted := make([]string, len(types))
+	for i, t := range types {
+		if !slices.Contains(clearSiteDataTypes, t) {
+			return fmt.Errorf("hh.ClearSiteData: unknown type %q", t)
+		}
+		quoted[i] = `"` + t + `"`
+	}
+	w.Header().Set("Clear-Site-Data", strings.Join(quoted, ", "))
+	return nil
+}
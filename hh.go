@@ -3,30 +3,76 @@ package hh
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // An HTTPResponseError is an error that can render itself as an HTTP response.
+// Wrap finds an HTTPResponseError wrapped by another error if the wrapper has an Unwrap method,
+// as used by package errors; see also RegisterUnwrapper.
 type HTTPResponseError interface {
 	error
 	RenderHTTP(w http.ResponseWriter)
 }
 
+// An HTTPRequestRenderer is an HTTPResponseError that uses the request to render its response.
+// When an error resolves to an HTTPRequestRenderer, Wrap calls RenderHTTPRequest instead of RenderHTTP.
+// RenderHTTP is still used when no request is available.
+//
+// An error with a RenderHTTPRequest method but no RenderHTTP method is also accepted wherever
+// an HTTPResponseError is; when no request is available, it renders as if for a GET request with no headers.
+type HTTPRequestRenderer interface {
+	HTTPResponseError
+	RenderHTTPRequest(w http.ResponseWriter, r *http.Request)
+}
+
 // ResponseError is a convenience type that implements HTTPResponseError.
+// It renders like http.Error, with StatusText as a plain text body,
+// unless Header overrides the Content-Type.
 type ResponseError struct {
-	StatusCode int    // the HTTP status code to respond with
-	StatusText string // the text that accompanies the status code
+	StatusCode int         // the HTTP status code to respond with
+	StatusText string      // the text that accompanies the status code
+	Header     http.Header // additional response headers, if any; they override the defaults
+	Cause      error       // the underlying error, if any; it is included in Error but never sent to the client
 }
 
 var _ HTTPResponseError = (*ResponseError)(nil)
 
 func (e *ResponseError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%d: %v: %v", e.StatusCode, e.StatusText, e.Cause)
+	}
 	return fmt.Sprintf("%d: %v", e.StatusCode, e.StatusText)
 }
 
+// Unwrap returns e's Cause.
+func (e *ResponseError) Unwrap() error {
+	return e.Cause
+}
+
 func (e *ResponseError) RenderHTTP(w http.ResponseWriter) {
-	http.Error(w, e.StatusText, e.StatusCode)
+	if len(e.Header) == 0 {
+		http.Error(w, e.StatusText, e.StatusCode)
+		return
+	}
+	// Like http.Error, which would overwrite a custom Content-Type.
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	for k, v := range e.Header {
+		h[k] = v
+	}
+	w.WriteHeader(e.StatusCode)
+	fmt.Fprintln(w, e.StatusText)
 }
 
 // Error returns a ResponseError with status statusCode, with the default status text.
@@ -44,28 +90,121 @@ func Errorf(statusCode int, format string, args ...any) error {
 	return &ResponseError{StatusCode: statusCode, StatusText: fmt.Sprintf(format, args...)}
 }
 
-// ErrorJSON returns a ResponseError with status statusCode, accompanied by data encoded as JSON.
+// ErrorCause returns a ResponseError with status statusCode and text s, caused by cause.
+// The client receives only s; cause appears in the error's text, for logging,
+// and is available to errors.Is and errors.As.
+func ErrorCause(statusCode int, s string, cause error) error {
+	return &ResponseError{StatusCode: statusCode, StatusText: s, Cause: cause}
+}
+
+// ErrorJSON returns a ResponseError with status statusCode, accompanied by data encoded as JSON,
+// with Content-Type application/json.
 // If data cannot be JSON-encoded, ErrorJSON returns an error created with fmt.Errorf.
 // In this case, the response to the client will be an HTTP 500 (Internal Server Error)
 // with default 500 status text, and the error will contain details of the encoding failure.
-// ErrorJSON does not set the Content-Type header.
-// To do that, implement a custom HTTPResponseError.
 func ErrorJSON(statusCode int, data any) error {
 	buf, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("hh.ErrorJSON: encoding failed: %w (value: %#v)", err, data)
 	}
-	return &ResponseError{StatusCode: statusCode, StatusText: string(buf)}
+	h := http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+	return &ResponseError{StatusCode: statusCode, StatusText: string(buf), Header: h}
+}
+
+// ErrorJSONf returns an HTTPResponseError with status statusCode and a JSON body of the form
+//
+//	{"error": "<message>"}
+//
+// where message is formatted using fmt.Sprintf, with Content-Type application/json.
+// The error's text includes the message, for logging.
+func ErrorJSONf(statusCode int, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	buf, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{msg}) // cannot fail
+	h := make(http.Header)
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	return &response{code: statusCode, header: h, body: buf, msg: msg}
+}
+
+// ErrorXML returns an HTTPResponseError with status statusCode, accompanied by data encoded as XML
+// using encoding/xml, preceded by the standard XML header,
+// with Content-Type application/xml.
+// If data cannot be XML-encoded, ErrorXML returns an error created with fmt.Errorf,
+// with the same consequences as for ErrorJSON.
+func ErrorXML(statusCode int, data any) error {
+	return ErrorXMLType(statusCode, "application/xml; charset=utf-8", data)
+}
+
+// ErrorXMLType is like ErrorXML, but responds with the given Content-Type,
+// such as "text/xml; charset=utf-8".
+func ErrorXMLType(statusCode int, contentType string, data any) error {
+	buf, err := xml.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("hh.ErrorXML: encoding failed: %w (value: %#v)", err, data)
+	}
+	h := make(http.Header)
+	h.Set("Content-Type", contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	return &response{code: statusCode, header: h, body: append([]byte(xml.Header), buf...)}
+}
+
+// BadRequestIf returns an error with status 400 (Bad Request) and Sprintf-formatted text if cond is true.
+// Otherwise it returns nil. It is convenient for validation:
+//
+//	if err := hh.BadRequestIf(id == "", "missing id"); err != nil {
+//		return err
+//	}
+func BadRequestIf(cond bool, format string, args ...any) error {
+	return errorIf(cond, http.StatusBadRequest, format, args)
+}
+
+// UnauthorizedIf is like BadRequestIf, with status 401 (Unauthorized).
+func UnauthorizedIf(cond bool, format string, args ...any) error {
+	return errorIf(cond, http.StatusUnauthorized, format, args)
+}
+
+// ForbiddenIf is like BadRequestIf, with status 403 (Forbidden).
+func ForbiddenIf(cond bool, format string, args ...any) error {
+	return errorIf(cond, http.StatusForbidden, format, args)
+}
+
+// NotFoundIf is like BadRequestIf, with status 404 (Not Found).
+func NotFoundIf(cond bool, format string, args ...any) error {
+	return errorIf(cond, http.StatusNotFound, format, args)
+}
+
+func errorIf(cond bool, statusCode int, format string, args []any) error {
+	if !cond {
+		return nil
+	}
+	return Errorf(statusCode, format, args...)
 }
 
 var (
-	ErrBadRequest          = Error(http.StatusBadRequest)
-	ErrUnauthorized        = Error(http.StatusUnauthorized)
-	ErrMethodNotAllowed    = Error(http.StatusMethodNotAllowed)
-	ErrNotFound            = Error(http.StatusNotFound)
-	ErrTooManyRequests     = Error(http.StatusTooManyRequests)
-	ErrInternalServerError = Error(http.StatusInternalServerError)
-	ErrServiceUnavailable  = Error(http.StatusServiceUnavailable)
+	ErrBadRequest            = Error(http.StatusBadRequest)
+	ErrUnauthorized          = Error(http.StatusUnauthorized)
+	ErrMethodNotAllowed      = Error(http.StatusMethodNotAllowed)
+	ErrNotFound              = Error(http.StatusNotFound)
+	ErrPreconditionFailed    = Error(http.StatusPreconditionFailed)
+	ErrPreconditionRequired  = Error(http.StatusPreconditionRequired)
+	ErrLengthRequired        = Error(http.StatusLengthRequired)
+	ErrRequestEntityTooLarge = Error(http.StatusRequestEntityTooLarge)
+	ErrUnsupportedMediaType  = Error(http.StatusUnsupportedMediaType)
+	ErrTooManyRequests       = Error(http.StatusTooManyRequests)
+	ErrInternalServerError   = Error(http.StatusInternalServerError)
+	ErrServiceUnavailable    = Error(http.StatusServiceUnavailable)
+	ErrGatewayTimeout        = Error(http.StatusGatewayTimeout)
+)
+
+// Misuse of a wrapped handler's http.ResponseWriter results in one of these errors,
+// which render as a 500 (Internal Server Error).
+// They are distinct so that errorware and logs can tell handler bugs from other failures using errors.Is.
+var (
+	ErrHeadersAfterBody      = ErrorText(http.StatusInternalServerError, "headers modified after being sent")
+	ErrMultipleWriteHeader   = ErrorText(http.StatusInternalServerError, "multiple calls to WriteHeader")
+	ErrWriteHeaderAfterWrite = ErrorText(http.StatusInternalServerError, "WriteHeader called after Write")
 )
 
 // A HandlerFunc is an http.HandlerFunc that returns an error. See Wrap.
@@ -73,117 +212,545 @@ type HandlerFunc func(http.ResponseWriter, *http.Request) error
 
 // Wrap converts h to a standard http.HandlerFunc.
 //
-// All errors returned by h are passed through the errorware, in order.
+// All errors returned by h are passed through the errorware, in order,
+// after any default errorware (see SetDefaultErrorware).
+// A panic in h is recovered and handled as a *PanicError.
+// If errorware converts a non-nil error to nil, the error is considered resolved,
+// and whatever h wrote before returning is sent, exactly as if h had succeeded;
+// see WithEmptyOnResolve for an alternative.
 // After errorware has been applied, non-nil errors are converted to HTTP 500s (internal server error),
 // unless they implement HTTPResponseError, or wrap an error that does,
 // in which case the error renders the response.
+// The outermost HTTPResponseError in the chain is used.
+// If an error wraps several errors, such as one created with errors.Join,
+// and more than one of them resolves to an HTTPResponseError,
+// the one with the highest status code is used, so that a 5xx takes precedence over a 4xx;
+// among those with equal status codes, the first is used.
 //
-// Wrap buffers output and response headers until h returns.
+// Wrap buffers output and response headers until h returns (but see WithMaxBufferSize).
 // This ensures that errors are correctly sent to the client.
+// For HEAD requests, the buffered body is discarded,
+// but its length, which is the length a GET would send, is reported in the Content-Length header.
+// If h wrote a body and also set a Content-Length that disagrees with it, the body's length wins.
+// If h wrote no body, as a handler that special-cases HEAD might, any Content-Length it set is kept.
 // For this reason, a wrapped handler's http.ResponseWriter
 // does not implement http.Flusher or http.Hijacker.
-// If this is not acceptable, do not use Wrap for this handler.
+// If this is not acceptable, use WrapStreaming for this handler.
+//
+// Informational (1xx) responses, such as 103 (Early Hints), are not buffered:
+// if h calls WriteHeader with a 1xx code before its final status code,
+// the informational response is sent immediately, with the headers set so far, as with net/http.
+// Those headers remain in the buffered header, to be sent with the final response, unless h removes them.
+// Handlers with a time limit (see TimeoutHandler) cannot send informational responses; they are dropped.
+//
+// Trailers work as with net/http: after writing the body, h may set headers that it declared
+// in the Trailer header, or whose names begin with http.TrailerPrefix, and they are sent after the body.
+// Changing any other header after writing the body is an error.
+//
+// With http.ResponseController, a wrapped handler may call SetReadDeadline, SetWriteDeadline,
+// and EnableFullDuplex, which apply to the underlying connection immediately;
+// the write deadline therefore also limits sending the buffered response.
+// Flush returns http.ErrNotSupported, unless the response has switched to pass-through mode
+// (see WithMaxBufferSize), and Hijack always returns http.ErrNotSupported.
+// Handlers with a time limit (see TimeoutHandler) get http.ErrNotSupported for every operation,
+// since they may outlive the request.
 // This package is designed to allow mix-and-match with non-error-returning handlers.
 func Wrap(h HandlerFunc, errorware ...func(*http.Request, error) error) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		bufw := new(bufferingResponseWriter)
-		err := h(bufw, r)
-		if bufw.err != nil {
-			if err != nil {
-				err = fmt.Errorf("response write error (%v) after handler error: %w", bufw.err, err)
-			} else {
-				err = bufw.err
+	return defaultWrapper.Wrap(h, errorware...)
+}
+
+// AsHTTPResponseError returns the HTTPResponseError that Wrap would use to render err, if any.
+// It searches err's chain exactly as Wrap does, including errors that wrap multiple errors.
+func AsHTTPResponseError(err error) (HTTPResponseError, bool) {
+	re := asHTTPResponseError(err)
+	return re, re != nil
+}
+
+// AsResponseError is like AsHTTPResponseError, but reports only a *ResponseError.
+// If the HTTPResponseError that Wrap would use is of another type, AsResponseError returns nil, false.
+func AsResponseError(err error) (*ResponseError, bool) {
+	re, ok := asHTTPResponseError(err).(*ResponseError)
+	return re, ok
+}
+
+func asHTTPResponseError(err error) HTTPResponseError {
+	for err != nil {
+		switch x := err.(type) {
+		case *ResponseError:
+			// fast path: the most common HTTPResponseError, no interface lookup needed
+			return x
+		case HTTPResponseError:
+			return x
+		case requestRenderer:
+			return requestOnly{x}
+		case interface{ Unwrap() error }:
+			err = x.Unwrap()
+		case interface{ Unwrap() []error }:
+			// Of several HTTPResponseErrors, the one with the highest status code wins,
+			// so that the result does not depend on the order of the wrapped errors.
+			var best HTTPResponseError
+			bestCode := 0
+			for _, err := range x.Unwrap() {
+				hre := asHTTPResponseError(err)
+				if hre == nil {
+					continue
+				}
+				if best == nil {
+					best = hre
+					continue
+				}
+				if bestCode == 0 {
+					bestCode = statusCodeOf(best)
+				}
+				if code := statusCodeOf(hre); code > bestCode {
+					best, bestCode = hre, code
+				}
+			}
+			return best
+		default:
+			if err = customUnwrap(err); err == nil {
+				return nil
 			}
 		}
-		for _, fn := range errorware {
-			err = fn(r, err)
-		}
-		if err == nil {
-			bufw.flush(w)
-			return
-		}
+	}
+	return nil
+}
 
-		re := asHTTPResponseError(err)
-		if re == nil {
-			// not an HTTPResponseError, convert to 500
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-		re.RenderHTTP(w)
+// unwrappers are the functions registered by RegisterUnwrapper.
+var (
+	unwrappersMu sync.Mutex
+	unwrappers   atomic.Pointer[[]func(error) error]
+)
+
+// RegisterUnwrapper registers fn for finding the HTTPResponseError in an error chain.
+//
+// Wrap sees through errors that have an Unwrap() error or Unwrap() []error method, as package errors does.
+// For wrapper types that cannot have such a method, such as types from other packages,
+// fn returns the error wrapped by err, or nil if err is not such a wrapper.
+// Registered functions are consulted, in order of registration,
+// only for errors in the chain that have no Unwrap method and are not HTTPResponseErrors.
+//
+// RegisterUnwrapper is intended to be called during initialization, such as from an init function.
+func RegisterUnwrapper(fn func(error) error) {
+	unwrappersMu.Lock()
+	defer unwrappersMu.Unlock()
+	var fns []func(error) error
+	if p := unwrappers.Load(); p != nil {
+		fns = slices.Clip(*p)
 	}
+	fns = append(fns, fn)
+	unwrappers.Store(&fns)
 }
 
-func asHTTPResponseError(err error) HTTPResponseError {
-	for {
-		if hre, ok := err.(HTTPResponseError); ok {
-			return hre
+// customUnwrap returns the error wrapped by err according to the functions registered by RegisterUnwrapper,
+// or nil if there is none.
+func customUnwrap(err error) error {
+	p := unwrappers.Load()
+	if p == nil {
+		return nil
+	}
+	for _, fn := range *p {
+		if inner := fn(err); inner != nil {
+			return inner
 		}
+	}
+	return nil
+}
+
+// statusCodeOf returns the status code with which re renders.
+// It uses a StatusCode method or a known status code, if any.
+// Otherwise, as a last resort, it renders re, discarding the result.
+func statusCodeOf(re HTTPResponseError) int {
+	switch x := re.(type) {
+	case *ResponseError:
+		return x.StatusCode
+	case *response:
+		return x.code
+	case *handlerError:
+		return x.code
+	case *redirect:
+		return x.code
+	case *NegotiatingError:
+		return x.StatusCode
+	case *RetryableError:
+		return x.StatusCode
+	case interface{ StatusCode() int }:
+		return x.StatusCode()
+	}
+	bufw := new(bufferingResponseWriter)
+	bufw.buffer = &bufw.buf
+	re.RenderHTTP(bufw)
+	if !bufw.wroteCode {
+		return http.StatusOK
+	}
+	return bufw.code
+}
+
+// RootCause returns the underlying cause of err, for logging:
+// the deepest error in err's chain that is not an HTTPResponseError.
+// Where Wrap reports what the client sees, such as "500 Internal Server Error",
+// RootCause reports what went wrong, such as "connection refused".
+//
+// For an error that wraps multiple errors, such as one created with errors.Join,
+// RootCause searches the wrapped errors depth-first, in order,
+// and returns the first error it finds that wraps nothing and is not an HTTPResponseError.
+// If there is no such error, RootCause returns err.
+func RootCause(err error) error {
+	if cause := rootCause(err); cause != nil {
+		return cause
+	}
+	return err
+}
+
+// rootCause implements RootCause, returning nil if there is no cause.
+func rootCause(err error) error {
+	for err != nil {
 		switch x := err.(type) {
 		case interface{ Unwrap() error }:
-			err = x.Unwrap()
-			if err == nil {
-				return nil
+			inner := x.Unwrap()
+			if inner == nil {
+				return leafCause(err)
 			}
-			// continue outer for loop
+			err = inner
 		case interface{ Unwrap() []error }:
 			for _, err := range x.Unwrap() {
-				if hre := asHTTPResponseError(err); hre != nil {
-					return hre
+				if cause := rootCause(err); cause != nil {
+					return cause
 				}
 			}
 			return nil
 		default:
-			return nil
+			return leafCause(err)
 		}
 	}
+	return nil
+}
+
+// leafCause returns err, which wraps nothing, if it is a cause for RootCause, and nil otherwise.
+func leafCause(err error) error {
+	switch err.(type) {
+	case HTTPResponseError, requestRenderer:
+		return nil
+	}
+	return err
 }
 
 type bufferingResponseWriter struct {
 	header    http.Header
-	buffer    bytes.Buffer
+	buffer    Buffer       // the body; usually points to buf
+	buf       bytes.Buffer // default buffer
 	code      int
 	wroteCode bool
 	wroteBody bool
+	head      bool  // responding to a HEAD request; don't send the body
 	err       error // Accumulate response writing errors
+	written   int64 // total body bytes written by the handler
+
+	dst         http.ResponseWriter // where the response will be sent, if known
+	max         int64               // see WithMaxBufferSize; 0 for no limit
+	passThrough bool                // the response has been sent to dst; write directly to dst
+
+	onPassThrough func(size int64) // called on switching to pass-through, if non-nil; see WithOversizeHook
+
+	late    http.Header // the header as seen by the handler after the body was written; see checkLateHeader
+	trailer http.Header // trailers set by the handler
+
+	nilAsEmpty bool       // see WithJSONNilAsEmpty
+	noCache    bool       // see DoNotCache
+	cookies    *CookieSet // see Cookies; nil until first use
 }
 
+func (w *bufferingResponseWriter) nilAsEmptyJSON() bool { return w.nilAsEmpty }
+
 func (w *bufferingResponseWriter) Header() http.Header {
 	if w.header == nil {
 		w.header = make(http.Header)
 	}
 	if w.wroteBody {
-		w.setError(ErrorText(http.StatusInternalServerError, "headers modified after being sent"))
-		// Still return a copy to prevent undefined behavior
-		h := make(http.Header, len(w.header))
-		for k, v := range w.header {
-			h[k] = v
+		// Only trailers may be set now. Return a copy, and check it when the handler returns.
+		if w.late == nil {
+			w.late = w.header.Clone()
 		}
-		return h
+		return w.late
 	}
 	return w.header
 }
 
+// checkLateHeader checks changes made to the header after the body was written,
+// once the handler has returned.
+// Changes to trailers, which are declared in the Trailer header or use http.TrailerPrefix,
+// are recorded, to be sent after the body. Any other change is an error.
+func (w *bufferingResponseWriter) checkLateHeader() {
+	if w.late == nil {
+		return
+	}
+	declared := make(map[string]bool)
+	for _, v := range w.header.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			declared[http.CanonicalHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+	for k, v := range w.late {
+		if slices.Equal(v, w.header[k]) {
+			continue
+		}
+		if !declared[k] && !strings.HasPrefix(k, http.TrailerPrefix) {
+			w.setError(ErrHeadersAfterBody)
+			return
+		}
+		if w.trailer == nil {
+			w.trailer = make(http.Header)
+		}
+		w.trailer[k] = v
+	}
+	for k := range w.header {
+		if _, ok := w.late[k]; !ok {
+			w.setError(ErrHeadersAfterBody)
+			return
+		}
+	}
+}
+
+// sendTrailers sets the trailers recorded by checkLateHeader on dst,
+// to which the body has been written.
+func (w *bufferingResponseWriter) sendTrailers(dst http.ResponseWriter) {
+	for k, v := range w.trailer {
+		dst.Header()[k] = v
+	}
+}
+
 func (w *bufferingResponseWriter) Write(b []byte) (int, error) {
+	w.beginWrite(len(b))
+	var n int
+	var err error
+	switch {
+	case !w.passThrough:
+		n, err = w.buffer.Write(b)
+	case w.head:
+		n = len(b)
+	default:
+		n, err = w.dst.Write(b)
+	}
+	w.written += int64(n)
+	return n, err
+}
+
+// WriteString implements io.StringWriter, to avoid copying s when the buffer allows.
+func (w *bufferingResponseWriter) WriteString(s string) (int, error) {
+	w.beginWrite(len(s))
+	var n int
+	var err error
+	switch {
+	case !w.passThrough:
+		n, err = io.WriteString(w.buffer, s)
+	case w.head:
+		n = len(s)
+	default:
+		n, err = io.WriteString(w.dst, s)
+	}
+	w.written += int64(n)
+	return n, err
+}
+
+// beginWrite prepares w for writing n body bytes.
+func (w *bufferingResponseWriter) beginWrite(n int) {
 	if !w.wroteCode {
 		w.WriteHeader(http.StatusOK)
 	}
 	w.wroteBody = true
-	return w.buffer.Write(b)
+	if size := int64(w.buffer.Len() + n); !w.passThrough && w.max > 0 && size > w.max {
+		w.startPassThrough(size)
+	}
+}
+
+// ReadFrom implements io.ReaderFrom, so that io.Copy to w uses a pooled copy buffer
+// rather than allocating one.
+func (w *bufferingResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	// Unlike Write, record the implicit WriteHeader only once something has been read,
+	// as with the io.Copy loop: copying from an empty reader leaves the status code unset.
+	var total int64
+	if !w.passThrough {
+		lim := src
+		if w.max > 0 {
+			lim = io.LimitReader(src, w.max-int64(w.buffer.Len()))
+		}
+		// Copy through a pooled buffer, rather than using bytes.Buffer's ReadFrom,
+		// which grows the buffer past what it needs when the size of src is unknown.
+		bp := copyBuffers.Get().(*[]byte)
+		n, err := io.CopyBuffer(writerOnly{w.buffer}, lim, *bp)
+		copyBuffers.Put(bp)
+		if n > 0 {
+			if !w.wroteCode {
+				w.WriteHeader(http.StatusOK)
+			}
+			w.wroteBody = true
+			w.written += n
+		}
+		total += n
+		if err != nil || w.max <= 0 {
+			return total, err
+		}
+		// The buffer is full. If there is more, switch to pass-through.
+		var b [1]byte
+		if _, err := io.ReadFull(src, b[:]); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return total, err
+		}
+		n1, err := w.Write(b[:])
+		total += int64(n1)
+		if err != nil {
+			return total, err
+		}
+	}
+	bp := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(bp)
+	n, err := io.CopyBuffer(writerOnly{w}, src, *bp)
+	return total + n, err
+}
+
+// copyBuffers is a pool of buffers for ReadFrom, of the size io.Copy uses.
+var copyBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 32<<10)
+		return &b
+	},
+}
+
+// writerOnly hides the methods of an io.Writer other than Write,
+// for use with io.Copy by ReadFrom methods, to avoid recursion.
+type writerOnly struct {
+	io.Writer
+}
+
+// The following methods support http.ResponseController; see Wrap.
+
+func (w *bufferingResponseWriter) SetReadDeadline(t time.Time) error {
+	if w.dst == nil {
+		return http.ErrNotSupported
+	}
+	return http.NewResponseController(w.dst).SetReadDeadline(t)
+}
+
+func (w *bufferingResponseWriter) SetWriteDeadline(t time.Time) error {
+	if w.dst == nil {
+		return http.ErrNotSupported
+	}
+	return http.NewResponseController(w.dst).SetWriteDeadline(t)
+}
+
+func (w *bufferingResponseWriter) EnableFullDuplex() error {
+	if w.dst == nil {
+		return http.ErrNotSupported
+	}
+	return http.NewResponseController(w.dst).EnableFullDuplex()
+}
+
+func (w *bufferingResponseWriter) FlushError() error {
+	if !w.passThrough {
+		return http.ErrNotSupported
+	}
+	return http.NewResponseController(w.dst).Flush()
+}
+
+// applyCookies adds the Set-Cookie headers for w's CookieSet, if any, to w's header,
+// just before it is sent.
+func (w *bufferingResponseWriter) applyCookies() {
+	if w.cookies == nil || len(w.cookies.cookies) == 0 {
+		return
+	}
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	w.cookies.apply(w.header)
+	w.cookies.cookies = nil
+}
+
+// startPassThrough sends the buffered response to w.dst,
+// and arranges for subsequent writes to go directly to w.dst.
+// The buffer retains its contents, but is not used again.
+// size is the size the body has reached, including the write that caused the switch.
+func (w *bufferingResponseWriter) startPassThrough(size int64) {
+	if w.onPassThrough != nil {
+		w.onPassThrough(size)
+	}
+	w.applyCookies()
+	for k, v := range w.header {
+		w.dst.Header()[k] = v
+	}
+	w.dst.WriteHeader(w.code)
+	if w.buffer.Len() > 0 && !w.head {
+		_, _ = w.dst.Write(w.buffer.Bytes())
+	}
+	w.passThrough = true
+}
+
+// bypass switches w to pass-through mode before anything has been written,
+// copying the header set so far to w.dst, and returns w.dst, to which the caller writes the response.
+func (w *bufferingResponseWriter) bypass() http.ResponseWriter {
+	w.applyCookies()
+	for k, v := range w.header {
+		w.dst.Header()[k] = v
+	}
+	w.passThrough = true
+	return w.dst
 }
 
 func (w *bufferingResponseWriter) WriteHeader(code int) {
+	if isInformational(code) && !w.wroteCode && !w.wroteBody {
+		w.writeInformational(code)
+		return
+	}
 	if w.wroteCode {
-		w.setError(ErrorText(http.StatusInternalServerError, "multiple calls to WriteHeader"))
+		w.setError(ErrMultipleWriteHeader)
 		return
 	}
 	if w.wroteBody {
-		w.setError(ErrorText(http.StatusInternalServerError, "WriteHeader called after Write"))
+		w.setError(ErrWriteHeaderAfterWrite)
 		return
 	}
 	w.code = code
 	w.wroteCode = true
 }
 
+// isInformational reports whether code is an informational (1xx) status code,
+// which may precede the final status code.
+// As for net/http, 101 (Switching Protocols) is final.
+func isInformational(code int) bool {
+	return code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols
+}
+
+// writeInformational sends an informational response with the current header, such as 103 (Early Hints),
+// directly to w.dst. With no dst, it is dropped.
+// The header is not left on dst: it is not yet known to belong to the final response.
+func (w *bufferingResponseWriter) writeInformational(code int) {
+	if w.dst == nil {
+		return
+	}
+	h := w.dst.Header()
+	saved := h.Clone()
+	for k, v := range w.header {
+		h[k] = v
+	}
+	w.dst.WriteHeader(code)
+	clear(h)
+	for k, v := range saved {
+		h[k] = v
+	}
+}
+
+// replaceBody replaces w's buffered body with a copy of body.
+// The Buffer in use is Reset, and the default buffer is used from then on.
+func (w *bufferingResponseWriter) replaceBody(body []byte) {
+	body = bytes.Clone(body) // body may alias the buffer
+	w.buffer.Reset()
+	w.buf.Reset()
+	w.buffer = &w.buf
+	w.buf.Write(body)
+	w.wroteBody = w.wroteBody || len(body) > 0
+}
+
 func (w *bufferingResponseWriter) setError(err error) {
 	if w.err == nil {
 		w.err = err
@@ -191,15 +758,58 @@ func (w *bufferingResponseWriter) setError(err error) {
 }
 
 func (w *bufferingResponseWriter) flush(dst http.ResponseWriter) {
+	w.applyCookies()
 	for k, v := range w.header {
 		dst.Header()[k] = v
 	}
+	if w.head && w.buffer.Len() > 0 {
+		// net/http discards HEAD bodies, and with them the length of the body that a GET would send.
+		// The body is authoritative: a stale or miscomputed Content-Length would
+		// mislead caches comparing HEAD and GET responses.
+		if n := strconv.Itoa(w.buffer.Len()); dst.Header().Get("Content-Length") != n {
+			dst.Header().Set("Content-Length", n)
+		}
+	}
 	if w.wroteCode {
 		dst.WriteHeader(w.code)
 	}
-	if w.buffer.Len() > 0 {
+	if w.buffer.Len() > 0 && !w.head {
 		// intentionally ignore errors
 		// there's little we can do about them
 		_, _ = dst.Write(w.buffer.Bytes())
 	}
+	w.sendTrailers(dst)
+}
+
+// Status returns the status code set so far on w, a response writer passed to a wrapped handler.
+// It returns the code passed to WriteHeader, if any;
+// 200 (OK) if the body has been written without calling WriteHeader;
+// and 0 if nothing has been written.
+// If w was not created by Wrap, and does not wrap such a writer via an Unwrap method,
+// the status is unknowable, and Status returns 0.
+func Status(w http.ResponseWriter) int {
+	bufw := bufferOf(w)
+	switch {
+	case bufw == nil:
+		return 0
+	case bufw.wroteCode:
+		return bufw.code
+	case bufw.wroteBody:
+		return http.StatusOK
+	}
+	return 0
+}
+
+// bufferOf returns the bufferingResponseWriter w or that w wraps, or nil if there is none.
+func bufferOf(w http.ResponseWriter) *bufferingResponseWriter {
+	for {
+		switch x := w.(type) {
+		case *bufferingResponseWriter:
+			return x
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = x.Unwrap()
+		default:
+			return nil
+		}
+	}
 }
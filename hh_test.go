@@ -0,0 +1,423 @@
+package hh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMaxBufferSize(t *testing.T) {
+	const max = 16
+	tests := []struct {
+		name        string
+		writes      []int // sizes of successive writes
+		passThrough bool
+	}{
+		{"max-1", []int{max - 1}, false},
+		{"max", []int{max}, false},
+		{"max+1", []int{max + 1}, true},
+		{"max then 1", []int{max, 1}, true},
+		{"max-1 then 1", []int{max - 1, 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []byte
+			h := func(w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("X-Before", "kept")
+				w.WriteHeader(http.StatusAccepted)
+				for i, n := range tt.writes {
+					b := bytes.Repeat([]byte{'a' + byte(i)}, n)
+					want = append(want, b...)
+					w.Write(b)
+				}
+				// An error after the body. It can be rendered only if nothing has been sent.
+				return errors.New("late failure")
+			}
+			rec := httptest.NewRecorder()
+			NewWrapper(WithMaxBufferSize(max)).Wrap(h)(rec, httptest.NewRequest("GET", "/", nil))
+
+			if !tt.passThrough {
+				if rec.Code != http.StatusInternalServerError {
+					t.Errorf("status = %d, want %d (error rendered from the buffer)", rec.Code, http.StatusInternalServerError)
+				}
+				if rec.Header().Get("X-Before") != "" {
+					t.Errorf("X-Before = %q, want none: the failed response's headers are discarded", rec.Header().Get("X-Before"))
+				}
+				return
+			}
+			if rec.Code != http.StatusAccepted {
+				t.Errorf("status = %d, want %d (pass-through)", rec.Code, http.StatusAccepted)
+			}
+			if got := rec.Header().Get("X-Before"); got != "kept" {
+				t.Errorf("X-Before = %q, want %q", got, "kept")
+			}
+			if !bytes.Equal(rec.Body.Bytes(), want) {
+				t.Errorf("body = %q, want %q", rec.Body.Bytes(), want)
+			}
+		})
+	}
+}
+
+// discardResponseWriter is an http.ResponseWriter that discards everything written to it.
+type discardResponseWriter struct {
+	h http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.h }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
+
+func BenchmarkReadFrom(b *testing.B) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 16<<10) // 256 KiB
+	r := httptest.NewRequest("GET", "/", nil)
+	benches := []struct {
+		name string
+		max  int64 // see WithMaxBufferSize
+		copy func(w io.Writer, src io.Reader) (int64, error)
+	}{
+		{"ReadFrom", 0, io.Copy},
+		{"Write", 0, func(w io.Writer, src io.Reader) (int64, error) { return io.Copy(writerOnly{w}, src) }},
+		{"ReadFrom/PassThrough", int64(len(data)) / 2, io.Copy},
+		{"Write/PassThrough", int64(len(data)) / 2, func(w io.Writer, src io.Reader) (int64, error) { return io.Copy(writerOnly{w}, src) }},
+		{"ReadFrom/AtMax", int64(len(data)), io.Copy},
+	}
+	for _, bb := range benches {
+		b.Run(bb.name, func(b *testing.B) {
+			wr := NewWrapper(WithMaxBufferSize(bb.max))
+			dst := &discardResponseWriter{h: make(http.Header)}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for range b.N {
+				bufw := wr.newBufferingResponseWriter(r, dst)
+				// Hide bytes.Reader's WriteTo method, so that io.Copy uses ReadFrom, if any.
+				src := struct{ io.Reader }{bytes.NewReader(data)}
+				if _, err := bb.copy(bufw, src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAsHTTPResponseError(b *testing.B) {
+	wrap := func(err error, depth int) error {
+		for range depth {
+			err = fmt.Errorf("layer: %w", err)
+		}
+		return err
+	}
+	plain := errors.New("plain")
+	benches := []struct {
+		name string
+		err  error
+	}{
+		{"ResponseError", ErrNotFound},
+		{"Depth1", wrap(ErrNotFound, 1)},
+		{"Depth4", wrap(ErrNotFound, 4)},
+		{"Depth16", wrap(ErrNotFound, 16)},
+		{"Plain/Depth16", wrap(plain, 16)},
+		{"Join", errors.Join(plain, ErrNotFound)},
+		{"Join/Two", errors.Join(ErrNotFound, Error(http.StatusForbidden))},
+		{"Join/None", errors.Join(plain, plain)},
+	}
+	for _, bb := range benches {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				asHTTPResponseError(bb.err)
+			}
+		})
+	}
+}
+
+// stringBuffer is a Buffer that records calls to WriteString.
+type stringBuffer struct {
+	bytes.Buffer
+	writeStrings int
+}
+
+func (b *stringBuffer) WriteString(s string) (int, error) {
+	b.writeStrings++
+	return b.Buffer.WriteString(s)
+}
+
+func TestWriteString(t *testing.T) {
+	var buf *stringBuffer
+	wr := NewWrapper(WithBufferFactory(func() Buffer {
+		buf = new(stringBuffer)
+		return buf
+	}))
+
+	t.Run("uses WriteString", func(t *testing.T) {
+		var status int
+		h := func(w http.ResponseWriter, r *http.Request) error {
+			io.WriteString(w, "hello, ")
+			io.WriteString(w, "world")
+			status = Status(w)
+			return nil
+		}
+		rec := httptest.NewRecorder()
+		wr.Wrap(h)(rec, httptest.NewRequest("GET", "/", nil))
+		if buf.writeStrings != 2 {
+			t.Errorf("buffer's WriteString called %d times, want 2", buf.writeStrings)
+		}
+		if status != http.StatusOK {
+			t.Errorf("Status after WriteString = %d, want %d (implicit)", status, http.StatusOK)
+		}
+		if rec.Code != http.StatusOK || rec.Body.String() != "hello, world" {
+			t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), http.StatusOK, "hello, world")
+		}
+	})
+
+	t.Run("WriteHeader after WriteString", func(t *testing.T) {
+		var got error
+		h := func(w http.ResponseWriter, r *http.Request) error {
+			io.WriteString(w, "hello")
+			w.WriteHeader(http.StatusNotFound) // too late: the implicit 200 has been recorded
+			return nil
+		}
+		errorware := func(r *http.Request, err error) error {
+			got = err
+			return err
+		}
+		rec := httptest.NewRecorder()
+		wr.Wrap(h, errorware)(rec, httptest.NewRequest("GET", "/", nil))
+		if !errors.Is(got, ErrMultipleWriteHeader) {
+			t.Errorf("errorware saw %v, want %v", got, ErrMultipleWriteHeader)
+		}
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+		}
+	})
+
+	t.Run("no allocation", func(t *testing.T) {
+		bufw := NewWrapper().newBufferingResponseWriter(httptest.NewRequest("GET", "/", nil), nil)
+		bufw.buf.Grow(1 << 10)
+		s := strings.Repeat("x", 100)
+		allocs := testing.AllocsPerRun(5, func() { io.WriteString(bufw, s) })
+		if allocs != 0 {
+			t.Errorf("io.WriteString allocated %v times, want 0", allocs)
+		}
+	})
+}
+
+// codeError is an HTTPResponseError with a StatusCode method that counts its renders.
+type codeError struct {
+	code    int
+	renders *int
+}
+
+func (e codeError) Error() string   { return http.StatusText(e.code) }
+func (e codeError) StatusCode() int { return e.code }
+func (e codeError) RenderHTTP(w http.ResponseWriter) {
+	*e.renders++
+	http.Error(w, e.Error(), e.code)
+}
+
+func TestJoinedErrorPrecedence(t *testing.T) {
+	notFound, forbidden := Error(http.StatusNotFound), Error(http.StatusForbidden)
+	for _, err := range []error{
+		errors.Join(notFound, forbidden),
+		errors.Join(forbidden, notFound),
+		errors.Join(errors.New("plain"), fmt.Errorf("wrapped: %w", forbidden), notFound),
+	} {
+		re := asHTTPResponseError(err)
+		if re != notFound {
+			t.Errorf("asHTTPResponseError(%q) = %v, want %v", err, re, notFound)
+		}
+		rec := httptest.NewRecorder()
+		Wrap(func(w http.ResponseWriter, r *http.Request) error { return err })(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%q: status = %d, want %d", err, rec.Code, http.StatusNotFound)
+		}
+	}
+
+	// A StatusCode method is used in preference to rendering.
+	var renders int
+	custom := codeError{http.StatusTooManyRequests, &renders}
+	if re := asHTTPResponseError(errors.Join(forbidden, custom)); re != custom {
+		t.Errorf("asHTTPResponseError(join of 403 and 429) = %v, want %v", re, custom)
+	}
+	if renders != 0 {
+		t.Errorf("custom error rendered %d times to find its status code, want 0", renders)
+	}
+}
+
+func TestErrorJSONf(t *testing.T) {
+	err := ErrorJSONf(http.StatusBadRequest, "bad id %q", "x\"y")
+	if got, want := err.Error(), `400: bad id "x\"y"`; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	rec := httptest.NewRecorder()
+	Wrap(func(w http.ResponseWriter, r *http.Request) error { return err })(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if got, want := rec.Header().Get("Content-Type"), "application/json; charset=utf-8"; got != want {
+		t.Errorf("Content-Type = %q, want %q", got, want)
+	}
+	if got, want := rec.Body.String(), `{"error":"bad id \"x\\\"y\""}`; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+func TestTrailers(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Trailer", "X-Sum")
+		io.WriteString(w, "body")
+		w.Header().Set("X-Sum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "def")
+		return nil
+	}
+	for name, wr := range map[string]*Wrapper{
+		"Wrap":             NewWrapper(),
+		"WithSingleFlight": NewWrapper(WithSingleFlight(func(r *http.Request) string { return r.URL.Path })),
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(wr.Wrap(h))
+			defer srv.Close()
+			res, err := http.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(res.Body) // trailers are available after the body
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != "body" {
+				t.Errorf("body = %q, want %q", body, "body")
+			}
+			if got := res.Trailer.Get("X-Sum"); got != "abc" {
+				t.Errorf("declared trailer X-Sum = %q, want %q", got, "abc")
+			}
+			if got := res.Trailer.Get("X-Late"); got != "def" {
+				t.Errorf("TrailerPrefix trailer X-Late = %q, want %q", got, "def")
+			}
+		})
+	}
+}
+
+func TestHeaderAfterBody(t *testing.T) {
+	var got error
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		io.WriteString(w, "body")
+		w.Header().Set("X-Undeclared", "too late")
+		return nil
+	}
+	errorware := func(r *http.Request, err error) error {
+		got = err
+		return err
+	}
+	rec := httptest.NewRecorder()
+	Wrap(h, errorware)(rec, httptest.NewRequest("GET", "/", nil))
+	if !errors.Is(got, ErrHeadersAfterBody) {
+		t.Errorf("errorware saw %v, want %v", got, ErrHeadersAfterBody)
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestInformational(t *testing.T) {
+	for _, code := range []int{http.StatusContinue, http.StatusEarlyHints} {
+		t.Run(strconv.Itoa(code), func(t *testing.T) {
+			h := func(w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("Link", "</style.css>; rel=preload")
+				w.WriteHeader(code)
+				w.Header().Del("Link")
+				w.WriteHeader(http.StatusOK)
+				io.WriteString(w, "final")
+				return nil
+			}
+			srv := httptest.NewServer(Wrap(h))
+			defer srv.Close()
+			var got []int
+			trace := &httptrace.ClientTrace{
+				Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+					got = append(got, code)
+					return nil
+				},
+			}
+			req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", srv.URL, nil)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK || string(body) != "final" {
+				t.Errorf("got %d %q, want %d %q", res.StatusCode, body, http.StatusOK, "final")
+			}
+			if res.Header.Get("Link") != "" {
+				t.Errorf("final response has the informational response's Link header")
+			}
+			// The client handles 100 (Continue) itself.
+			if code != http.StatusContinue && !slices.Equal(got, []int{code}) {
+				t.Errorf("informational responses = %v, want [%d]", got, code)
+			}
+		})
+	}
+
+	t.Run("second final status", func(t *testing.T) {
+		var got error
+		h := func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusOK)
+			w.WriteHeader(http.StatusCreated)
+			return nil
+		}
+		errorware := func(r *http.Request, err error) error {
+			got = err
+			return err
+		}
+		rec := httptest.NewRecorder()
+		Wrap(h, errorware)(rec, httptest.NewRequest("GET", "/", nil))
+		if !errors.Is(got, ErrMultipleWriteHeader) {
+			t.Errorf("errorware saw %v, want %v", got, ErrMultipleWriteHeader)
+		}
+	})
+}
+
+func TestHeadContentLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string // Content-Length set by the handler, if any
+		body   string
+		want   string
+	}{
+		{"unset", "", "hello", "5"},
+		{"consistent", "5", "hello", "5"},
+		{"inconsistent", "3", "hello", "5"},
+		{"no body", "42", "", "42"}, // a handler that special-cases HEAD knows best
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := func(w http.ResponseWriter, r *http.Request) error {
+				if tt.header != "" {
+					w.Header().Set("Content-Length", tt.header)
+				}
+				io.WriteString(w, tt.body)
+				return nil
+			}
+			rec := httptest.NewRecorder()
+			Wrap(h)(rec, httptest.NewRequest("HEAD", "/", nil))
+			if got := rec.Header().Get("Content-Length"); got != tt.want {
+				t.Errorf("Content-Length = %q, want %q", got, tt.want)
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("HEAD response has body %q", rec.Body.String())
+			}
+		})
+	}
+}
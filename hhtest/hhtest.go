@@ -0,0 +1,29 @@
+// Package hhtest provides utilities for testing handlers written for package hh.
+package hhtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/josharian/hh"
+)
+
+// Invoke serves r using h, wrapped by hh.Wrap with errorware, and returns the recorded response.
+// The response is exactly what a client would receive,
+// including error responses rendered from h's errors and the 500s for errors that are not HTTPResponseErrors.
+// Use httptest.NewRequest to create r.
+func Invoke(h hh.HandlerFunc, r *http.Request, errorware ...func(*http.Request, error) error) *httptest.ResponseRecorder {
+	return InvokeWrapper(nil, h, r, errorware...)
+}
+
+// InvokeWrapper is like Invoke, but wraps h using wr.Wrap.
+// If wr is nil, it uses hh.Wrap.
+func InvokeWrapper(wr *hh.Wrapper, h hh.HandlerFunc, r *http.Request, errorware ...func(*http.Request, error) error) *httptest.ResponseRecorder {
+	wrap := hh.Wrap
+	if wr != nil {
+		wrap = wr.Wrap
+	}
+	rec := httptest.NewRecorder()
+	wrap(h, errorware...)(rec, r)
+	return rec
+}
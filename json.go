@@ -0,0 +1,138 @@
+package hh
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// WithEnforceJSON requires successful responses to be JSON.
+//
+// After the handler returns, if its response has a 2xx status code and a non-empty body,
+// its Content-Type must be application/json or a JSON-based type such as application/problem+json.
+// If it is not, the handler fails with a 500 (Internal Server Error),
+// exactly as if it had misused its http.ResponseWriter.
+// If setMissing is true, a response with no Content-Type at all
+// is instead given a Content-Type of application/json.
+//
+// This catches handlers that write JSON but forget to declare it,
+// and handlers that accidentally write something else.
+func WithEnforceJSON(setMissing bool) Option {
+	return func(wr *Wrapper) {
+		wr.enforceJSON = true
+		wr.setMissingJSON = setMissing
+	}
+}
+
+// checkJSON implements WithEnforceJSON.
+func (wr *Wrapper) checkJSON(bufw *bufferingResponseWriter) {
+	if bufw.buffer.Len() == 0 {
+		return
+	}
+	if bufw.wroteCode && (bufw.code < 200 || bufw.code > 299) {
+		return
+	}
+	ct := bufw.header.Get("Content-Type")
+	if ct == "" && wr.setMissingJSON {
+		if bufw.header == nil {
+			bufw.header = make(http.Header)
+		}
+		bufw.header.Set("Content-Type", "application/json; charset=utf-8")
+		return
+	}
+	if !isJSONType(ct) {
+		bufw.setError(ErrorText(http.StatusInternalServerError, "response is not JSON"))
+	}
+}
+
+// isJSONType reports whether the Content-Type ct is a JSON media type.
+func isJSONType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// A Schema validates JSON documents.
+// It is typically an adapter for a JSON Schema implementation.
+type Schema interface {
+	// Validate returns a non-nil error if the JSON document doc does not conform to the schema.
+	Validate(doc []byte) error
+}
+
+// WithResponseSchema validates successful JSON responses against schemas, keyed by route name.
+//
+// After the handler returns, if its response has a 2xx status code, a JSON Content-Type, and a non-empty body,
+// and there is a schema for the route name set by WithRouteName, the body must pass validation.
+// If it does not, the handler fails with a 500 (Internal Server Error),
+// exactly as if it had misused its http.ResponseWriter.
+// The validation error remains in the error chain, for logging.
+// Routes without a schema, and Wrappers without a route name, are not checked.
+//
+// Validation is expensive. This option is intended for tests and staging,
+// to catch responses that have drifted from the API contract.
+func WithResponseSchema(schemas map[string]Schema) Option {
+	return func(wr *Wrapper) {
+		wr.schemas = schemas
+	}
+}
+
+// checkSchema implements WithResponseSchema.
+func (wr *Wrapper) checkSchema(bufw *bufferingResponseWriter) {
+	s := wr.schemas[wr.routeName]
+	if s == nil || bufw.buffer.Len() == 0 {
+		return
+	}
+	if bufw.wroteCode && (bufw.code < 200 || bufw.code > 299) {
+		return
+	}
+	if !isJSONType(bufw.header.Get("Content-Type")) {
+		return
+	}
+	if err := s.Validate(bufw.buffer.Bytes()); err != nil {
+		bufw.setError(fmt.Errorf("%w: %w", ErrorText(http.StatusInternalServerError, "response does not match schema"), err))
+	}
+}
+
+// WithJSONNilAsEmpty makes WriteJSON, and therefore JSON and JSONLimit,
+// encode a nil slice as [] and a nil map as {}, instead of null.
+// Clients usually expect an empty collection, not null, from an endpoint that returns a collection.
+// Only the value passed to WriteJSON is affected, not nil slices and maps within it.
+func WithJSONNilAsEmpty() Option {
+	return func(wr *Wrapper) {
+		wr.jsonNilAsEmpty = true
+	}
+}
+
+// emptyJSON returns the JSON encoding of an empty collection to use in place of null for v,
+// which encoded as null, if w is subject to WithJSONNilAsEmpty, and nil otherwise.
+func emptyJSON(w http.ResponseWriter, v any) []byte {
+	if !nilAsEmptyJSON(w) {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Kind() == reflect.Slice && rv.IsNil():
+		return []byte("[]")
+	case rv.Kind() == reflect.Map && rv.IsNil():
+		return []byte("{}")
+	}
+	return nil
+}
+
+// nilAsEmptyJSON reports whether w is subject to WithJSONNilAsEmpty.
+func nilAsEmptyJSON(w http.ResponseWriter) bool {
+	for {
+		switch x := w.(type) {
+		case interface{ nilAsEmptyJSON() bool }:
+			return x.nilAsEmptyJSON()
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = x.Unwrap()
+		default:
+			return false
+		}
+	}
+}
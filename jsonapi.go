@@ -0,0 +1,86 @@
+package hh
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A JSONAPIError is an HTTPResponseError that renders as a JSON:API error document
+// (https://jsonapi.org/format/#errors), with Content-Type application/vnd.api+json.
+//
+// The response status is the highest Status among its Errors,
+// or 500 (Internal Server Error) if none has a Status.
+type JSONAPIError struct {
+	Errors []JSONAPIErrorObject `json:"errors"`
+}
+
+// A JSONAPIErrorObject is a single JSON:API error object.
+type JSONAPIErrorObject struct {
+	Status int            `json:"status,string,omitempty"` // the HTTP status code applicable to this problem
+	Code   string         `json:"code,omitempty"`          // an application-specific error code
+	Title  string         `json:"title,omitempty"`         // a short summary of the problem
+	Detail string         `json:"detail,omitempty"`        // an explanation specific to this occurrence of the problem
+	Source *JSONAPISource `json:"source,omitempty"`        // the part of the request that caused the problem
+}
+
+// A JSONAPISource identifies the part of a request that caused a JSON:API error.
+type JSONAPISource struct {
+	Pointer   string `json:"pointer,omitempty"`   // a JSON Pointer (RFC 6901) into the request document, such as "/data/attributes/title"
+	Parameter string `json:"parameter,omitempty"` // the query parameter that caused the error
+	Header    string `json:"header,omitempty"`    // the request header that caused the error
+}
+
+var _ HTTPResponseError = (*JSONAPIError)(nil)
+
+// ErrorJSONAPI returns a JSONAPIError containing errs.
+func ErrorJSONAPI(errs ...JSONAPIErrorObject) error {
+	return &JSONAPIError{Errors: errs}
+}
+
+// StatusCode returns the HTTP status code with which e renders.
+func (e *JSONAPIError) StatusCode() int {
+	code := 0
+	for _, obj := range e.Errors {
+		code = max(code, obj.Status)
+	}
+	if code == 0 {
+		return http.StatusInternalServerError
+	}
+	return code
+}
+
+func (e *JSONAPIError) Error() string {
+	code := e.StatusCode()
+	var msgs []string
+	for _, obj := range e.Errors {
+		msg := obj.Title
+		if obj.Detail != "" {
+			msg = obj.Detail
+		}
+		if msg != "" {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) == 0 {
+		return fmt.Sprintf("%d: %v", code, http.StatusText(code))
+	}
+	return fmt.Sprintf("%d: %v", code, strings.Join(msgs, "; "))
+}
+
+func (e *JSONAPIError) RenderHTTP(w http.ResponseWriter) {
+	doc := *e
+	if doc.Errors == nil {
+		doc.Errors = []JSONAPIErrorObject{}
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		// unreachable: JSONAPIError contains only strings and ints
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.WriteHeader(e.StatusCode())
+	_, _ = w.Write(buf)
+}
@@ -0,0 +1,160 @@
+package hh
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// WithMaxHeaders limits the number of distinct headers a handler may set on a response to n.
+// A handler that exceeds the limit fails with a 500 (Internal Server Error),
+// exactly as if it had misused its http.ResponseWriter.
+// The limit is checked after the handler returns, before errorware runs.
+// A Set-Cookie header with many values counts once.
+func WithMaxHeaders(n int) Option {
+	return func(wr *Wrapper) {
+		wr.maxHeaders = n
+	}
+}
+
+// WithConcurrencyLimit limits each wrapped handler to n concurrent executions,
+// rejecting requests beyond the limit immediately.
+//
+// When a request arrives while n executions of the handler are already running,
+// the handler is not run; instead, onReject is handled, exactly as if the handler had returned it.
+// If onReject is nil, a 503 (Service Unavailable) with a Retry-After header of 1 second is used.
+// Requests never wait for an execution to finish: there is no queue,
+// so clients should retry, or servers that prefer to queue should limit concurrency elsewhere.
+// A request whose context is already done is not run either; its context's error is handled instead.
+//
+// Each call to Wrap creates a separate limit, shared by all requests to that handler.
+func WithConcurrencyLimit(n int, onReject error) Option {
+	return func(wr *Wrapper) {
+		wr.concurrency = n
+		wr.onReject = onReject
+	}
+}
+
+// limitConcurrency implements WithConcurrencyLimit.
+func limitConcurrency(h HandlerFunc, n int, onReject error) HandlerFunc {
+	if onReject == nil {
+		onReject = textResponse(http.StatusServiceUnavailable, http.Header{"Retry-After": {"1"}})
+	}
+	sem := make(chan struct{}, n)
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			return h(w, r)
+		default:
+			return onReject
+		}
+	}
+}
+
+// An OversizeResponse describes a response body that exceeded the limit set by WithOversizeHook.
+type OversizeResponse struct {
+	Route  string // the route name set by WithRouteName, if any
+	Method string // the request method
+	Path   string // the request path
+	Size   int64  // the size of the response body, in bytes
+	Limit  int64  // the limit that was exceeded
+}
+
+// WithOversizeHook calls fn when a handler writes a response body larger than limit bytes.
+// fn is called after the handler returns, before errorware runs,
+// so it sees the full size of the body the handler wrote.
+// It is intended for finding endpoints that produce unexpectedly large responses,
+// which Wrap must hold in memory.
+//
+// A response that switches to pass-through mode (see WithMaxBufferSize) is no longer held in memory.
+// For such a response, fn is called at the switch instead, if the body has by then exceeded limit,
+// with Size the size reached at that moment, including the write that caused the switch.
+func WithOversizeHook(limit int64, fn func(*http.Request, OversizeResponse)) Option {
+	return func(wr *Wrapper) {
+		wr.oversizeLimit = limit
+		wr.onOversize = fn
+	}
+}
+
+// checkOversize implements WithOversizeHook.
+// size is the size of the response body.
+func (wr *Wrapper) checkOversize(r *http.Request, size int64) {
+	if size <= wr.oversizeLimit {
+		return
+	}
+	wr.onOversize(r, OversizeResponse{
+		Route:  RouteName(r),
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Size:   size,
+		Limit:  wr.oversizeLimit,
+	})
+}
+
+// RequestGuards configures WithRequestGuards. The zero value of each field disables its check.
+type RequestGuards struct {
+	// MaxBodySize is the largest request body allowed, in bytes.
+	// Requests that declare a larger Content-Length are rejected with ErrRequestEntityTooLarge,
+	// and other requests' bodies are limited using http.MaxBytesReader,
+	// whose errors MapStdErrors converts to 413 (Request Entity Too Large).
+	MaxBodySize int64
+
+	// ContentTypes lists the media types, such as "application/json", allowed for request bodies.
+	// Requests with a body of any other media type, or with none, are rejected with ErrUnsupportedMediaType.
+	// Media type parameters, such as charset, are ignored.
+	ContentTypes []string
+
+	// RequireLength, if true, rejects POST, PUT, and PATCH requests
+	// that have no Content-Length, such as chunked requests, with ErrLengthRequired.
+	RequireLength bool
+}
+
+// WithRequestGuards checks requests against g before calling the handler.
+// A request that fails a check is not passed to the handler;
+// instead, the corresponding error is handled, exactly as if the handler had returned it.
+// Checks run before any concurrency limit, so rejected requests are cheap.
+func WithRequestGuards(g RequestGuards) Option {
+	return func(wr *Wrapper) {
+		g.ContentTypes = slices.Clone(g.ContentTypes)
+		wr.requestGuards = &g
+	}
+}
+
+// guardRequests implements WithRequestGuards.
+func guardRequests(h HandlerFunc, g *RequestGuards) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if err := g.check(r); err != nil {
+			return err
+		}
+		if g.MaxBodySize > 0 && r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, g.MaxBodySize)
+		}
+		return h(w, r)
+	}
+}
+
+// check checks r against g.
+func (g *RequestGuards) check(r *http.Request) error {
+	hasBody := r.ContentLength != 0 && r.Body != nil && r.Body != http.NoBody
+	if g.RequireLength && r.ContentLength < 0 {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+			return ErrLengthRequired
+		}
+	}
+	if g.MaxBodySize > 0 && r.ContentLength > g.MaxBodySize {
+		return ErrRequestEntityTooLarge
+	}
+	if len(g.ContentTypes) > 0 && hasBody {
+		mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || !slices.ContainsFunc(g.ContentTypes, func(t string) bool { return strings.EqualFold(t, mt) }) {
+			return ErrUnsupportedMediaType
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package hh
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOversizeHook(t *testing.T) {
+	const limit = 8
+	tests := []struct {
+		name     string
+		max      int64 // see WithMaxBufferSize
+		writes   []int // sizes of successive writes
+		wantSize int64 // the size reported, or 0 for no call
+	}{
+		{"under limit", 0, []int{limit}, 0},
+		{"buffered", 0, []int{limit, 4, 4}, limit + 8},
+		{"pass-through", 12, []int{limit, 6, 100}, limit + 6},
+		{"pass-through under limit", 4, []int{2, 4, 100}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []OversizeResponse
+			hook := WithOversizeHook(limit, func(r *http.Request, o OversizeResponse) {
+				got = append(got, o)
+			})
+			h := func(w http.ResponseWriter, r *http.Request) error {
+				for _, n := range tt.writes {
+					w.Write(bytes.Repeat([]byte{'a'}, n))
+				}
+				return nil
+			}
+			NewWrapper(hook, WithMaxBufferSize(tt.max)).Wrap(h)(httptest.NewRecorder(), httptest.NewRequest("GET", "/big", nil))
+			if tt.wantSize == 0 {
+				if len(got) != 0 {
+					t.Errorf("hook called with %+v, want no call", got)
+				}
+				return
+			}
+			if len(got) != 1 {
+				t.Fatalf("hook called %d times, want 1", len(got))
+			}
+			want := OversizeResponse{Method: "GET", Path: "/big", Size: tt.wantSize, Limit: limit}
+			if got[0] != want {
+				t.Errorf("hook called with %+v, want %+v", got[0], want)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	running, release := make(chan struct{}), make(chan struct{})
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		running <- struct{}{}
+		<-release
+		return nil
+	}
+	wrapped := NewWrapper(WithConcurrencyLimit(1, nil)).Wrap(h)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wrapped(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	<-running // the limiter is full
+
+	rec := httptest.NewRecorder()
+	wrapped(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+	close(release)
+	<-done
+
+	// Once the running request finishes, its slot is free.
+	rec = httptest.NewRecorder()
+	go func() { <-running }()
+	wrapped(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("after release: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,84 @@
+package hh
+
+import (
+	"net/http"
+	"strings"
+)
+
+// readMethods is the Allow header value for handlers created by DeriveReadMethods.
+const readMethods = "GET, HEAD, OPTIONS"
+
+// DeriveReadMethods returns a handler for a read-only resource whose GET requests are handled by get.
+//
+// GET requests are passed to get, exactly as with Wrap.
+// HEAD requests are also passed to get; the buffered body is discarded,
+// but its length is reported in the Content-Length header.
+// OPTIONS requests receive a 204 (No Content) with an Allow header listing GET, HEAD, and OPTIONS.
+// All other methods receive ErrMethodNotAllowed, also with an Allow header.
+func DeriveReadMethods(get HandlerFunc, errorware ...func(*http.Request, error) error) http.HandlerFunc {
+	return defaultWrapper.DeriveReadMethods(get, errorware...)
+}
+
+// DeriveReadMethods is like the package-level DeriveReadMethods, using wr to wrap get.
+func (wr *Wrapper) DeriveReadMethods(get HandlerFunc, errorware ...func(*http.Request, error) error) http.HandlerFunc {
+	wrapped := wr.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return get(w, r)
+		case http.MethodOptions:
+			w.Header().Set("Allow", readMethods)
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		return ErrMethodNotAllowed
+	}, errorware...)
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			// Set directly on w: buffered headers are discarded when rendering errors.
+			w.Header().Set("Allow", readMethods)
+		}
+		wrapped(w, r)
+	}
+}
+
+// NotFoundHandler returns a handler that responds to every request with ErrNotFound,
+// passed through errorware and rendered exactly as with Wrap.
+// This gives unmatched routes the same error responses as wrapped handlers.
+// With http.ServeMux, register it for the pattern "/", which matches any request no other pattern does.
+func NotFoundHandler(errorware ...func(*http.Request, error) error) http.HandlerFunc {
+	return defaultWrapper.NotFoundHandler(errorware...)
+}
+
+// NotFoundHandler is like the package-level NotFoundHandler, using wr's options.
+func (wr *Wrapper) NotFoundHandler(errorware ...func(*http.Request, error) error) http.HandlerFunc {
+	return wr.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		return ErrNotFound
+	}, errorware...)
+}
+
+// MethodNotAllowedHandler returns a handler that responds to every request with ErrMethodNotAllowed,
+// passed through errorware and rendered exactly as with Wrap,
+// with an Allow header listing allow, if non-empty.
+// It is intended for routers that accept a handler for unsupported methods.
+// (http.ServeMux generates its own 405 responses; to replace them,
+// register MethodNotAllowedHandler for the pattern without a method.)
+func MethodNotAllowedHandler(allow []string, errorware ...func(*http.Request, error) error) http.HandlerFunc {
+	return defaultWrapper.MethodNotAllowedHandler(allow, errorware...)
+}
+
+// MethodNotAllowedHandler is like the package-level MethodNotAllowedHandler, using wr's options.
+func (wr *Wrapper) MethodNotAllowedHandler(allow []string, errorware ...func(*http.Request, error) error) http.HandlerFunc {
+	wrapped := wr.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		return ErrMethodNotAllowed
+	}, errorware...)
+	allowed := strings.Join(allow, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowed != "" {
+			// Set directly on w: buffered headers are discarded when rendering errors.
+			w.Header().Set("Allow", allowed)
+		}
+		wrapped(w, r)
+	}
+}
@@ -0,0 +1,41 @@
+package hh
+
+import "net/http"
+
+// A Mux is an http.ServeMux whose handlers are all wrapped.
+// Its HandleFunc method accepts only a HandlerFunc, which it wraps,
+// so an unwrapped handler cannot be registered by mistake.
+// Create one with NewMux.
+type Mux struct {
+	wr  *Wrapper
+	mux *http.ServeMux
+}
+
+// NewMux returns a new Mux whose handlers are wrapped using the given options.
+func NewMux(opts ...Option) *Mux {
+	return NewWrapper(opts...).NewMux()
+}
+
+// NewMux returns a new Mux whose handlers are wrapped using wr.
+func (wr *Wrapper) NewMux() *Mux {
+	return &Mux{wr: wr, mux: http.NewServeMux()}
+}
+
+// HandleFunc registers h, wrapped with errorware, for pattern, as with http.ServeMux.HandleFunc.
+// Unless the Mux's options include WithRouteName, the route name is pattern.
+//
+// Requests that match no pattern, or match a pattern but not its method,
+// receive http.ServeMux's own responses, which Wrap does not handle.
+// To handle unmatched requests, register a HandlerFunc that returns ErrNotFound for the pattern "/".
+func (m *Mux) HandleFunc(pattern string, h HandlerFunc, errorware ...func(*http.Request, error) error) {
+	wr := m.wr
+	if wr.routeName == "" {
+		wr = wr.With(WithRouteName(pattern))
+	}
+	m.mux.HandleFunc(pattern, wr.Wrap(h, errorware...))
+}
+
+// ServeHTTP implements http.Handler.
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.ServeHTTP(w, r)
+}
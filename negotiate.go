@@ -0,0 +1,129 @@
+package hh
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A requestRenderer is an error that renders itself using the request,
+// but does not implement HTTPResponseError.
+type requestRenderer interface {
+	error
+	RenderHTTPRequest(w http.ResponseWriter, r *http.Request)
+}
+
+// requestOnly adapts a requestRenderer to HTTPRequestRenderer.
+type requestOnly struct {
+	requestRenderer
+}
+
+// RenderHTTP renders e as if for a GET request with no headers.
+func (e requestOnly) RenderHTTP(w http.ResponseWriter) {
+	r := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: "/"},
+		Proto:  "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header: make(http.Header),
+	}
+	e.RenderHTTPRequest(w, r.WithContext(context.Background()))
+}
+
+// A NegotiatingError is an HTTPRequestRenderer whose format depends on the request's Accept header.
+// Clients that prefer JSON receive a JSON object with Content-Type application/json, such as
+//
+//	{"error":"no such widget","status":404}
+//
+// Other clients receive Message as plain text, as with ErrorText.
+// A JSON media type, such as application/json or application/problem+json, is preferred
+// if it ranks above every text type and */*: by quality value, then by specificity,
+// and otherwise by being listed first. For example, both
+// "application/json, text/plain, */*" and "application/json;q=0.9, */*;q=0.9" prefer JSON,
+// but "text/plain, application/json" does not.
+type NegotiatingError struct {
+	StatusCode int    // the HTTP status code to respond with
+	Message    string // the error message; if empty, the default status text is used
+}
+
+var _ HTTPRequestRenderer = (*NegotiatingError)(nil)
+
+func (e *NegotiatingError) message() string {
+	if e.Message == "" {
+		return http.StatusText(e.StatusCode)
+	}
+	return e.Message
+}
+
+func (e *NegotiatingError) Error() string {
+	return strconv.Itoa(e.StatusCode) + ": " + e.message()
+}
+
+// RenderHTTP renders e as plain text.
+func (e *NegotiatingError) RenderHTTP(w http.ResponseWriter) {
+	http.Error(w, e.message(), e.StatusCode)
+}
+
+func (e *NegotiatingError) RenderHTTPRequest(w http.ResponseWriter, r *http.Request) {
+	if !prefersJSON(r.Header.Values("Accept")) {
+		e.RenderHTTP(w)
+		return
+	}
+	buf, _ := json.Marshal(struct {
+		Error  string `json:"error"`
+		Status int    `json:"status"`
+	}{e.message(), e.StatusCode}) // cannot fail
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.StatusCode)
+	_, _ = w.Write(buf)
+}
+
+// prefersJSON reports whether the Accept header values accept rank a JSON media type
+// above every text type and */*.
+// Ranges are ranked by quality value, then by specificity, so that an explicitly listed type
+// beats */* at the same quality, and then by the order in which they are listed.
+func prefersJSON(accept []string) bool {
+	var (
+		best     float64 // the quality value of the best range so far
+		bestSpec int     // its specificity: 2 for a full type, 1 for text/*, 0 for */*
+		bestJSON bool    // whether it is a JSON type
+	)
+	for _, v := range accept {
+		for _, rng := range strings.Split(v, ",") {
+			mt, params, err := mime.ParseMediaType(strings.TrimSpace(rng))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if s, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(s, 64); err != nil {
+					continue
+				}
+			}
+			var spec int
+			isJSON := false
+			switch {
+			case mt == "application/json" || strings.HasSuffix(mt, "+json"):
+				spec, isJSON = 2, true
+			case mt == "*/*":
+				spec = 0
+			case mt == "text/*":
+				spec = 1
+			case strings.HasPrefix(mt, "text/"):
+				spec = 2
+			default:
+				continue
+			}
+			if q > best || q > 0 && q == best && spec > bestSpec {
+				best, bestSpec, bestJSON = q, spec, isJSON
+			}
+		}
+	}
+	return bestJSON
+}
@@ -0,0 +1,143 @@
+package hh
+
+import (
+	"context"
+	"crypto/sha256"
+	"hash"
+	"net/http"
+)
+
+// A Result describes a response sent by a wrapped handler.
+type Result struct {
+	StatusCode int   // the status code sent to the client
+	Size       int64 // the number of body bytes sent to the client
+	Err        error // the error that determined the response, after errorware; nil on success
+}
+
+// WithAfterRequest calls fn after each response has been sent.
+//
+// fn observes every response, not only errors.
+// In particular, it sees the status code of responses that the handler wrote itself,
+// such as a handler that calls WriteHeader(http.StatusNotFound) and returns nil,
+// which the errorware chain never sees as an error.
+// The status code and size are those actually sent, whether the response was written by the handler
+// or rendered from an error, including the 500 (Internal Server Error) sent for errors that are not HTTPResponseErrors.
+// The size of the response to a HEAD request is 0.
+// This makes it a good place for metrics.
+//
+// Multiple WithAfterRequest options are called in order.
+func WithAfterRequest(fn func(*http.Request, Result)) Option {
+	return func(wr *Wrapper) {
+		wr.after = append(wr.after, fn)
+	}
+}
+
+// WithAsyncObserver calls fn with the final error (nil on success)
+// after each response has been sent, in a new goroutine.
+// It is intended for side effects, such as metrics and logging,
+// that should not delay the response.
+// Unlike errorware, fn cannot change the response.
+//
+// fn receives a copy of the request, made with http.Request.Clone,
+// whose context is not canceled when the request completes
+// but whose body has been replaced with http.NoBody:
+// by the time fn runs, the server may have closed the original body.
+// Values reachable from the copy that are not deep-copied by Clone,
+// such as values stored in its context, may be in use concurrently.
+//
+// Multiple WithAsyncObserver options are called in order, in a single goroutine.
+func WithAsyncObserver(fn func(*http.Request, error)) Option {
+	return func(wr *Wrapper) {
+		wr.async = append(wr.async, fn)
+	}
+}
+
+// observeAsync implements WithAsyncObserver.
+func (wr *Wrapper) observeAsync(r *http.Request, err error) {
+	rc := r.Clone(context.WithoutCancel(r.Context()))
+	rc.Body = http.NoBody
+	rc.GetBody = nil
+	go func() {
+		for _, fn := range wr.async {
+			fn(rc, err)
+		}
+	}()
+}
+
+// An outputWriter wraps the underlying http.ResponseWriter,
+// recording what is sent to the client
+// and making wr's final header adjustments before the header is sent.
+type outputWriter struct {
+	http.ResponseWriter
+	wr     *Wrapper
+	r      *http.Request // the request being responded to
+	code   int           // the status code sent, or 0 if none yet
+	size   int64         // the number of body bytes sent
+	again  bool          // a second response is being rendered; see WriteHeader
+	digest hash.Hash     // digest of the body sent, if any; see WithDigestTrailer
+}
+
+// newOutputWriter returns an outputWriter for sending the response to r over w.
+func (wr *Wrapper) newOutputWriter(w http.ResponseWriter, r *http.Request) *outputWriter {
+	out := &outputWriter{ResponseWriter: w, wr: wr, r: r}
+	if wr.digestTrailer != "" && r.Method != http.MethodHead {
+		out.digest = sha256.New()
+	}
+	return out
+}
+
+func (w *outputWriter) WriteHeader(code int) {
+	if isInformational(code) && w.code == 0 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.code != 0 {
+		// Rendering twice, due perhaps to a RenderHTTP method that calls back into Wrap,
+		// produces a confusing response. Keep the first, discarding the rest, and say who did it.
+		w.again = true
+		w.wr.logger().WarnContext(w.r.Context(), "hh: response rendered more than once; ignoring second response",
+			"route", RouteName(w.r), "method", w.r.Method, "path", w.r.URL.Path, "first", w.code, "second", code)
+		return
+	}
+	w.code = code
+	w.wr.finishHeader(w.Header())
+	if w.wr.requestIDHeader != "" {
+		w.Header().Set(w.wr.requestIDHeader, RequestID(w.r))
+	}
+	if w.digest != nil {
+		w.Header().Add("Trailer", w.wr.digestTrailer)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *outputWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.again {
+		// Part of a second response; don't mix it into the first.
+		return len(b), nil
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	if w.digest != nil {
+		w.digest.Write(b[:n])
+	}
+	return n, err
+}
+
+func (w *outputWriter) nilAsEmptyJSON() bool { return w.wr.jsonNilAsEmpty }
+
+// Unwrap returns the underlying http.ResponseWriter, for use by http.ResponseController.
+func (w *outputWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// status returns the status code of the response.
+// If nothing has been written, net/http sends a 200.
+func (w *outputWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
@@ -0,0 +1,44 @@
+package hh
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerWrittenErrorStatus(t *testing.T) {
+	var results []Result
+	wr := NewWrapper(WithAfterRequest(func(r *http.Request, res Result) {
+		results = append(results, res)
+	}))
+	var errorwareSaw []error
+	errorware := func(r *http.Request, err error) error {
+		errorwareSaw = append(errorwareSaw, err)
+		return err
+	}
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "no such thing")
+		return nil
+	}
+	rec := httptest.NewRecorder()
+	wr.Wrap(h, errorware)(rec, httptest.NewRequest("GET", "/", nil))
+
+	// The response is flushed exactly as written.
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "no such thing" || rec.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("got %d %q (Content-Type %q), want the handler's 404", rec.Code, rec.Body.String(), rec.Header().Get("Content-Type"))
+	}
+	// It is not an error...
+	for _, err := range errorwareSaw {
+		if err != nil {
+			t.Errorf("errorware saw %v, want nil", err)
+		}
+	}
+	// ...but observers see its status.
+	want := Result{StatusCode: http.StatusNotFound, Size: int64(len("no such thing"))}
+	if len(results) != 1 || results[0] != want {
+		t.Errorf("WithAfterRequest saw %+v, want [%+v]", results, want)
+	}
+}
@@ -0,0 +1,127 @@
+package hh
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+// A PanicError records a panic in a wrapped handler.
+//
+// Wrap recovers panics in handlers and handles them as errors of type *PanicError,
+// passing them through errorware like any other error;
+// use errors.As to detect them, for example to log the stack.
+// Unless errorware converts it, a PanicError renders as a 500 (Internal Server Error),
+// and anything the handler had written is discarded.
+// If Value is an error, the PanicError wraps it.
+//
+// Panics with the value http.ErrAbortHandler are not recovered,
+// so that handlers can still abort a response deliberately.
+type PanicError struct {
+	Value any    // the value passed to panic
+	Stack []byte // the stack of the panicking goroutine; see WithPanicStackDepth and WithPanicStackFormatter
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("hh: handler panicked: %v", e.Value)
+}
+
+// Unwrap returns e.Value if it is an error, and nil otherwise.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// recoverPanic converts a panic in progress, if any, into a *PanicError stored in *err.
+// A panic with the value http.ErrAbortHandler is recorded in *err and then continued.
+// recoverPanic must be deferred directly.
+func (wr *Wrapper) recoverPanic(err *error) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	if p == http.ErrAbortHandler {
+		*err = &PanicError{Value: p}
+		panic(p)
+	}
+	if wr.metrics != nil {
+		wr.metrics.panics.Add(1)
+	}
+	*err = &PanicError{Value: p, Stack: wr.panicStack()}
+}
+
+// defaultStackDepth is the default maximum number of frames in a PanicError's Stack.
+const defaultStackDepth = 64
+
+// WithPanicStackDepth limits the stacks recorded in PanicErrors to n frames, nearest the panic first.
+// Capturing and formatting deep stacks is costly; the default is 64 frames.
+// If n is negative, no stack is recorded.
+func WithPanicStackDepth(n int) Option {
+	return func(wr *Wrapper) {
+		wr.stackDepth = n
+	}
+}
+
+// WithPanicStackFormatter formats the stacks recorded in PanicErrors using format.
+// format receives the frames of the panicking goroutine, nearest the panic first,
+// beginning with the runtime's own panic frames.
+// The default format resembles that of runtime/debug.Stack:
+// a function name on one line, and its file and line number, indented, on the next.
+// See CleanStack for an alternative.
+func WithPanicStackFormatter(format func(frames []runtime.Frame) []byte) Option {
+	return func(wr *Wrapper) {
+		wr.formatStack = format
+	}
+}
+
+// CleanStack is a stack formatter for WithPanicStackFormatter
+// that formats frames like the default, but omits frames in the Go runtime and in this package,
+// leaving only the frames that describe the caller's code.
+func CleanStack(frames []runtime.Frame) []byte {
+	var keep []runtime.Frame
+	for _, f := range frames {
+		if strings.HasPrefix(f.Function, "runtime.") || strings.HasPrefix(f.Function, "github.com/josharian/hh.") {
+			continue
+		}
+		keep = append(keep, f)
+	}
+	return formatFrames(keep)
+}
+
+// panicStack returns the stack of the current, panicking goroutine, as configured by wr.
+// It must be called directly by recoverPanic.
+func (wr *Wrapper) panicStack() []byte {
+	depth := wr.stackDepth
+	switch {
+	case depth < 0:
+		return nil
+	case depth == 0:
+		depth = defaultStackDepth
+	}
+	pcs := make([]uintptr, depth)
+	n := runtime.Callers(3, pcs) // skip runtime.Callers, panicStack, and recoverPanic
+	iter := runtime.CallersFrames(pcs[:n])
+	var frames []runtime.Frame
+	for {
+		f, more := iter.Next()
+		frames = append(frames, f)
+		if !more {
+			break
+		}
+	}
+	if wr.formatStack != nil {
+		return wr.formatStack(frames)
+	}
+	return formatFrames(frames)
+}
+
+// formatFrames formats frames in the style of runtime/debug.Stack.
+func formatFrames(frames []runtime.Frame) []byte {
+	var b bytes.Buffer
+	for _, f := range frames {
+		fmt.Fprintf(&b, "%s(...)\n\t%s:%d\n", f.Function, f.File, f.Line)
+	}
+	return b.Bytes()
+}
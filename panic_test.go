@@ -0,0 +1,114 @@
+package hh
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPanicAfterPartialWrite(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("X-Partial", "1")
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, "partial")
+		panic("boom")
+	}
+	rec := httptest.NewRecorder()
+	Wrap(h)(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if got, want := rec.Body.String(), "Internal Server Error\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if rec.Header().Get("X-Partial") != "" {
+		t.Errorf("response has the panicking handler's header")
+	}
+}
+
+func TestPanicErrorware(t *testing.T) {
+	failure := errors.New("failure")
+	for _, value := range []any{"boom", failure} {
+		var pe *PanicError
+		errorware := func(r *http.Request, err error) error {
+			errors.As(err, &pe)
+			return err
+		}
+		h := func(w http.ResponseWriter, r *http.Request) error { panic(value) }
+		Wrap(h, errorware)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		if pe == nil {
+			t.Fatalf("panic(%v): errorware did not see a PanicError", value)
+		}
+		if pe.Value != value {
+			t.Errorf("PanicError.Value = %v, want %v", pe.Value, value)
+		}
+		if !strings.Contains(string(pe.Stack), "TestPanicErrorware") {
+			t.Errorf("PanicError.Stack does not include the panicking function:\n%s", pe.Stack)
+		}
+		if err, ok := value.(error); ok && !errors.Is(pe, err) {
+			t.Errorf("PanicError does not wrap the error it was given")
+		}
+	}
+}
+
+func TestPanicAbortHandler(t *testing.T) {
+	var errorwareCalled bool
+	errorware := func(r *http.Request, err error) error {
+		errorwareCalled = true
+		return err
+	}
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		io.WriteString(w, "partial")
+		panic(http.ErrAbortHandler)
+	}
+	rec := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler", p)
+			}
+		}()
+		Wrap(h, errorware)(rec, httptest.NewRequest("GET", "/", nil))
+	}()
+	if rec.Body.Len() != 0 {
+		t.Errorf("body = %q, want nothing rendered", rec.Body.String())
+	}
+	if errorwareCalled {
+		t.Errorf("errorware called for http.ErrAbortHandler")
+	}
+}
+
+func TestPanicExpvar(t *testing.T) {
+	const prefix = "hh_test_panics"
+	panics := expvarMetricsFor(prefix).panics
+	tests := []struct {
+		name  string
+		value any
+		opts  []Option
+	}{
+		{"recovered", "boom", nil},
+		{"ErrAbortHandler", http.ErrAbortHandler, nil},
+		{"timeout recovered", "boom", []Option{WithTimeouts(0, time.Second, nil)}},
+		{"timeout ErrAbortHandler", http.ErrAbortHandler, []Option{WithTimeouts(0, time.Second, nil)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := func(w http.ResponseWriter, r *http.Request) error { panic(tt.value) }
+			wr := NewWrapper(append([]Option{WithExpvar(prefix)}, tt.opts...)...)
+			for _, wrap := range []func(HandlerFunc, ...func(*http.Request, error) error) http.HandlerFunc{wr.Wrap, wr.WrapStreaming} {
+				before := panics.Value()
+				func() {
+					defer func() { recover() }()
+					wrap(h)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+				}()
+				if got := panics.Value() - before; got != 1 {
+					t.Errorf("panics counted %d times, want 1", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package hh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// A ProblemDetails is an HTTPResponseError that renders as a problem details object,
+// as specified by RFC 9457 (formerly RFC 7807), with Content-Type application/problem+json.
+//
+// The response status is Status, or 500 (Internal Server Error) if Status is 0.
+type ProblemDetails struct {
+	Type     string // a URI identifying the problem type; if empty, "about:blank" is implied
+	Title    string // a short summary of the problem type
+	Status   int    // the HTTP status code
+	Detail   string // an explanation specific to this occurrence of the problem
+	Instance string // a URI identifying this occurrence of the problem
+
+	// Extensions holds extension members, such as "invalid-params".
+	// They are encoded alongside the standard members, not nested.
+	// Keys must not be those of the standard members; see AddExtension.
+	Extensions map[string]any
+}
+
+var _ HTTPResponseError = (*ProblemDetails)(nil)
+
+// problemMembers are the standard members of a problem details object.
+var problemMembers = []string{"type", "title", "status", "detail", "instance"}
+
+// AddExtension sets the extension member key to value, and returns p.
+// It panics if key is the name of a standard member: "type", "title", "status", "detail", or "instance".
+func (p *ProblemDetails) AddExtension(key string, value any) *ProblemDetails {
+	if slices.Contains(problemMembers, key) {
+		panic(fmt.Sprintf("hh: problem details extension %q collides with a standard member", key))
+	}
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]any)
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+// StatusCode returns the HTTP status code with which p renders.
+func (p *ProblemDetails) StatusCode() int {
+	if p.Status == 0 {
+		return http.StatusInternalServerError
+	}
+	return p.Status
+}
+
+func (p *ProblemDetails) Error() string {
+	code := p.StatusCode()
+	msg := p.Detail
+	if msg == "" {
+		msg = p.Title
+	}
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	return fmt.Sprintf("%d: %v", code, msg)
+}
+
+// MarshalJSON encodes p as a problem details object.
+// It returns an error if an extension's key is that of a standard member,
+// or if an extension's value cannot be encoded.
+func (p *ProblemDetails) MarshalJSON() ([]byte, error) {
+	buf, err := json.Marshal(struct {
+		Type     string `json:"type,omitempty"`
+		Title    string `json:"title,omitempty"`
+		Status   int    `json:"status,omitempty"`
+		Detail   string `json:"detail,omitempty"`
+		Instance string `json:"instance,omitempty"`
+	}{p.Type, p.Title, p.Status, p.Detail, p.Instance})
+	if err != nil || len(p.Extensions) == 0 {
+		return buf, err
+	}
+	var b bytes.Buffer
+	b.Write(buf[:len(buf)-1]) // without the closing brace
+	for _, k := range slices.Sorted(maps.Keys(p.Extensions)) {
+		if slices.Contains(problemMembers, k) {
+			return nil, fmt.Errorf("hh: problem details extension %q collides with a standard member", k)
+		}
+		v, err := json.Marshal(p.Extensions[k])
+		if err != nil {
+			return nil, fmt.Errorf("hh: problem details extension %q: %w", k, err)
+		}
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k) // cannot fail
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func (p *ProblemDetails) RenderHTTP(w http.ResponseWriter) {
+	q := *p
+	q.Status = p.StatusCode() // so that the body agrees with the response
+	buf, err := q.MarshalJSON()
+	if err != nil {
+		// a bad extension; don't send a partial problem
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.StatusCode())
+	_, _ = w.Write(buf)
+}
@@ -0,0 +1,149 @@
+package hh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// WriteJSON writes v, encoded as JSON, to w with status statusCode
+// and Content-Type application/json.
+// If v cannot be encoded, WriteJSON writes nothing and returns an error created with fmt.Errorf,
+// which results in a 500 (Internal Server Error) when returned from a wrapped handler.
+// See also WithJSONNilAsEmpty.
+func WriteJSON(w http.ResponseWriter, statusCode int, v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("hh.WriteJSON: encoding failed: %w (value: %#v)", err, v)
+	}
+	if string(buf) == "null" {
+		if empty := emptyJSON(w, v); empty != nil {
+			buf = empty
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_, err = w.Write(buf)
+	return err
+}
+
+// A Reply builds a response that a handler returns, like an error,
+// so that successes and errors can be returned in the same style:
+//
+//	return hh.OK().JSON(thing)
+//	return hh.Respond(http.StatusCreated).Header("Location", url).JSON(thing)
+//
+// The methods that complete a Reply return an HTTPResponseError that renders the response.
+// Because it is an error, errorware sees it, and may inspect or replace it,
+// just as it would for a returned ErrorJSON.
+type Reply struct {
+	code   int
+	header http.Header
+}
+
+// OK returns a Reply with status 200 (OK).
+func OK() *Reply {
+	return Respond(http.StatusOK)
+}
+
+// Respond returns a Reply with status statusCode.
+func Respond(statusCode int) *Reply {
+	return &Reply{code: statusCode, header: make(http.Header)}
+}
+
+// Header sets the response header key to value, and returns b.
+func (b *Reply) Header(key, value string) *Reply {
+	b.header.Set(key, value)
+	return b
+}
+
+// JSON returns an HTTPResponseError that responds with v encoded as JSON,
+// with Content-Type application/json.
+// If v cannot be encoded, JSON returns an error created with fmt.Errorf,
+// with the same consequences as for ErrorJSON.
+func (b *Reply) JSON(v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("hh.Reply.JSON: encoding failed: %w (value: %#v)", err, v)
+	}
+	return b.Bytes("application/json; charset=utf-8", buf)
+}
+
+// Text returns an HTTPResponseError that responds with s, with Content-Type text/plain.
+func (b *Reply) Text(s string) error {
+	return b.Bytes("text/plain; charset=utf-8", []byte(s))
+}
+
+// Bytes returns an HTTPResponseError that responds with body and the given Content-Type.
+// If contentType is empty, none is set, and net/http detects one.
+func (b *Reply) Bytes(contentType string, body []byte) error {
+	h := b.header.Clone()
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	return &response{code: b.code, header: h, body: body}
+}
+
+// JSON returns a HandlerFunc for handlers whose request and response bodies are JSON.
+//
+// The request body is decoded into a value of type In.
+// An empty body decodes as the zero value.
+// A malformed body, or one containing more than a single JSON value, results in a 400 (Bad Request),
+// and fn is not called. The response does not describe the problem in detail,
+// since encoding/json's errors name Go types; the returned error wraps the decoding error, for logging.
+//
+// On success, fn's result is written with WriteJSON, with status 200 (OK).
+// If it cannot be encoded, the result is a 500 (Internal Server Error), as with WriteJSON.
+// Errors returned by fn are returned unchanged, exactly as from any HandlerFunc.
+func JSON[In, Out any](fn func(*http.Request, In) (Out, error)) HandlerFunc {
+	return JSONLimit(0, fn)
+}
+
+// JSONLimit is like JSON, but limits the request body to max bytes.
+// Larger bodies result in a 413 (Request Entity Too Large).
+// If max is 0, there is no limit.
+func JSONLimit[In, Out any](max int64, fn func(*http.Request, In) (Out, error)) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var in In
+		if r.Body != nil && r.Body != http.NoBody {
+			body := r.Body
+			if max > 0 {
+				body = http.MaxBytesReader(w, body, max)
+			}
+			if err := decodeJSON(body, &in); err != nil {
+				return err
+			}
+		}
+		out, err := fn(r, in)
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, out)
+	}
+}
+
+// decodeJSON decodes the single JSON value in r, if any, into v.
+// It returns an HTTPResponseError describing any problem.
+func decodeJSON(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	err := dec.Decode(v)
+	if err == nil {
+		if err = dec.Decode(new(json.RawMessage)); err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			return ErrorText(http.StatusBadRequest, "malformed JSON request body: more than one value")
+		}
+	}
+	if err == io.EOF {
+		return nil // empty body
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return withResponse(Error(http.StatusRequestEntityTooLarge), err)
+	}
+	// encoding/json's message names Go types and fields; keep it out of the response.
+	return withResponse(ErrorText(http.StatusBadRequest, "malformed JSON request body"), err)
+}
@@ -0,0 +1,83 @@
+package hh
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONHandler(t *testing.T) {
+	type in struct {
+		N int `json:"n"`
+	}
+	type out struct {
+		N int `json:"n"`
+		F any `json:"f,omitempty"`
+	}
+	h := JSONLimit(64, func(r *http.Request, v in) (out, error) {
+		switch v.N {
+		case -1:
+			return out{}, ErrNotFound
+		case -2:
+			return out{F: func() {}}, nil // cannot be encoded
+		}
+		return out{N: v.N * 2}, nil
+	})
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string // if non-empty, the exact response body
+		wantErr  func(error) bool
+	}{
+		{"ok", `{"n":21}`, http.StatusOK, `{"n":42}`, nil},
+		{"empty body", ``, http.StatusOK, `{"n":0}`, nil},
+		{"handler error", `{"n":-1}`, http.StatusNotFound, "Not Found\n", nil},
+		{
+			name: "decode error: syntax", body: `{"n":`, wantCode: http.StatusBadRequest,
+			wantBody: "malformed JSON request body\n",
+			wantErr:  func(err error) bool { return errors.Is(err, io.ErrUnexpectedEOF) },
+		},
+		{
+			name: "decode error: type", body: `{"n":"x"}`, wantCode: http.StatusBadRequest,
+			wantBody: "malformed JSON request body\n",
+			wantErr: func(err error) bool {
+				var te *json.UnmarshalTypeError
+				return errors.As(err, &te)
+			},
+		},
+		{"decode error: two values", `{"n":1} {"n":2}`, http.StatusBadRequest, "malformed JSON request body: more than one value\n", nil},
+		{"decode error: too large", `{"n":1}` + strings.Repeat(" ", 64), http.StatusRequestEntityTooLarge, "", nil},
+		{
+			name: "encode error", body: `{"n":-2}`, wantCode: http.StatusInternalServerError,
+			wantErr: func(err error) bool {
+				var ue *json.UnsupportedTypeError
+				return errors.As(err, &ue)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got error
+			errorware := func(r *http.Request, err error) error {
+				got = err
+				return err
+			}
+			rec := httptest.NewRecorder()
+			Wrap(h, errorware)(rec, httptest.NewRequest("POST", "/", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if tt.wantErr != nil && !tt.wantErr(got) {
+				t.Errorf("errorware saw %v, which does not have the expected cause", got)
+			}
+		})
+	}
+}
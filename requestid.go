@@ -0,0 +1,71 @@
+package hh
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// maxRequestID is the length of the longest request ID accepted from a client.
+const maxRequestID = 128
+
+// WithRequestID gives every request an ID, for tracing it through logs and across services.
+//
+// The ID is taken from the request header named header, "X-Request-Id" if empty.
+// If the request has no such header, or its value is longer than 128 bytes
+// or contains anything other than printable ASCII, the ID is generated by calling gen instead,
+// so that a client cannot inject arbitrary text into logs.
+// If gen is nil, the ID is 16 random bytes, hex-encoded.
+//
+// The ID is available to handlers and errorware using RequestID,
+// and is sent in the same header on every response, including error responses.
+func WithRequestID(header string, gen func() string) Option {
+	if header == "" {
+		header = "X-Request-Id"
+	}
+	if gen == nil {
+		gen = randomRequestID
+	}
+	return func(wr *Wrapper) {
+		wr.requestIDHeader = http.CanonicalHeaderKey(header)
+		wr.newRequestID = gen
+	}
+}
+
+// RequestID returns the ID of r set by WithRequestID,
+// or the empty string if there is none.
+func RequestID(r *http.Request) string {
+	if st := stateOf(r); st != nil {
+		return st.requestID
+	}
+	return ""
+}
+
+// requestID returns the ID for r, for WithRequestID.
+func (wr *Wrapper) requestID(r *http.Request) string {
+	id := r.Header.Get(wr.requestIDHeader)
+	if id == "" || !validRequestID(id) {
+		id = wr.newRequestID()
+	}
+	return id
+}
+
+// validRequestID reports whether id, from a client, is acceptable as a request ID.
+func validRequestID(id string) bool {
+	if len(id) > maxRequestID {
+		return false
+	}
+	for i := range len(id) {
+		if id[i] <= ' ' || id[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// randomRequestID is the default request ID generator for WithRequestID.
+func randomRequestID() string {
+	var b [16]byte
+	rand.Read(b[:]) // never fails
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,122 @@
+package hh
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// RequestLogOptions configures WithRequestLog.
+type RequestLogOptions struct {
+	// Level is the level at which requests are logged.
+	// If nil, requests are logged at slog.LevelDebug.
+	Level slog.Leveler
+
+	// Headers lists the request headers to log, with their values.
+	// Other headers are not logged, so that secrets are not logged by accident.
+	Headers []string
+
+	// Redact lists request headers to log without their values.
+	// If such a header is present, it is logged with the value "[REDACTED]",
+	// which records that it was sent without revealing it.
+	// Redact takes precedence over Headers.
+	Redact []string
+}
+
+// requestLog implements WithRequestLog.
+type requestLog struct {
+	logger  *slog.Logger
+	level   slog.Leveler
+	headers []string // canonical names, in order; redacted headers are last
+	redact  int      // index in headers of the first redacted header
+}
+
+// redacted is the logged value of a header listed in RequestLogOptions.Redact.
+const redacted = "[REDACTED]"
+
+// WithRequestLog logs every request to logger, after its response has been sent.
+// If logger is nil, slog.Default() is used.
+//
+// Each log entry records the request's method, URI, and protocol,
+// its route name (see WithRouteName) and ID (see WithRequestID), the headers selected by opts,
+// the status code and number of body bytes sent, and the final error, if any.
+// Because responses are buffered, the size is exact.
+//
+// This is intended for debugging; the entries are logged at slog.LevelDebug unless opts says otherwise.
+// Requests are not formatted for logging unless logger is enabled at that level.
+func WithRequestLog(logger *slog.Logger, opts RequestLogOptions) Option {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	rl := &requestLog{logger: logger, level: opts.Level}
+	if rl.level == nil {
+		rl.level = slog.LevelDebug
+	}
+	redact := make(map[string]bool)
+	for _, k := range opts.Redact {
+		redact[http.CanonicalHeaderKey(k)] = true
+	}
+	for _, k := range opts.Headers {
+		if k = http.CanonicalHeaderKey(k); !redact[k] {
+			rl.headers = append(rl.headers, k)
+		}
+	}
+	rl.redact = len(rl.headers)
+	for _, k := range opts.Redact {
+		rl.headers = append(rl.headers, http.CanonicalHeaderKey(k))
+	}
+	return func(wr *Wrapper) {
+		wr.requestLog = rl
+	}
+}
+
+// log logs the request r, whose response has been written to out.
+func (rl *requestLog) log(r *http.Request, out *outputWriter, err error) {
+	ctx := r.Context()
+	level := rl.level.Level()
+	if !rl.logger.Enabled(ctx, level) {
+		return
+	}
+	uri := r.RequestURI
+	if uri == "" {
+		// not a server request, as in tests
+		uri = r.URL.RequestURI()
+	}
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("uri", uri),
+		slog.String("proto", r.Proto),
+	}
+	if route := RouteName(r); route != "" {
+		attrs = append(attrs, slog.String("route", route))
+	}
+	if id := RequestID(r); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	var header []any
+	for i, k := range rl.headers {
+		v := r.Header.Values(k)
+		if len(v) == 0 {
+			continue
+		}
+		if i >= rl.redact {
+			header = append(header, slog.String(k, redacted))
+			continue
+		}
+		if len(v) == 1 {
+			header = append(header, slog.String(k, v[0]))
+		} else {
+			header = append(header, slog.Any(k, v))
+		}
+	}
+	if len(header) > 0 {
+		attrs = append(attrs, slog.Group("header", header...))
+	}
+	attrs = append(attrs,
+		slog.Int("status", out.status()),
+		slog.Int64("size", out.size),
+	)
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	rl.logger.LogAttrs(ctx, level, "hh: request", attrs...)
+}
@@ -0,0 +1,292 @@
+package hh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A response is an HTTPResponseError that renders a fixed status code, header, and body.
+// It is used by helpers that need more control over the response than ResponseError offers.
+type response struct {
+	code   int
+	header http.Header
+	body   []byte
+	msg    string // the text for Error, if not the default status text
+}
+
+var _ HTTPResponseError = (*response)(nil)
+
+func (e *response) Error() string {
+	if e.msg != "" {
+		return fmt.Sprintf("%d: %v", e.code, e.msg)
+	}
+	return fmt.Sprintf("%d: %v", e.code, http.StatusText(e.code))
+}
+
+func (e *response) RenderHTTP(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range e.header {
+		h[k] = v
+	}
+	w.WriteHeader(e.code)
+	if len(e.body) > 0 {
+		_, _ = w.Write(e.body)
+	}
+}
+
+// textResponse returns a response with status code and its default status text as a plain text body,
+// like that written by http.Error, with additional headers from h, which may be nil.
+func textResponse(code int, h http.Header) *response {
+	if h == nil {
+		h = make(http.Header)
+	}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	return &response{code: code, header: h, body: []byte(http.StatusText(code) + "\n")}
+}
+
+// HandlerError returns an HTTPResponseError that renders its response by calling h.ServeHTTP.
+// This allows reuse of existing http.Handlers, such as error pages, as error values.
+//
+// statusCode is advisory: it is used in the error text, for logging and classification,
+// but h controls what is actually written.
+// When rendered without a request, using RenderHTTP, the response is
+// a plain response with status statusCode and its default status text.
+func HandlerError(statusCode int, h http.Handler) error {
+	return &handlerError{code: statusCode, h: h}
+}
+
+type handlerError struct {
+	code int
+	h    http.Handler
+}
+
+var _ HTTPRequestRenderer = (*handlerError)(nil)
+
+func (e *handlerError) Error() string {
+	return fmt.Sprintf("%d: %v", e.code, http.StatusText(e.code))
+}
+
+func (e *handlerError) RenderHTTP(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(e.code), e.code)
+}
+
+func (e *handlerError) RenderHTTPRequest(w http.ResponseWriter, r *http.Request) {
+	e.h.ServeHTTP(w, r)
+}
+
+// Attachment returns an HTTPResponseError that responds with a 200 (OK)
+// containing data as a file download named filename.
+//
+// The response has the given Content-Type and a Content-Disposition of attachment.
+// Any directory components and control characters in filename are removed.
+// Non-ASCII filenames are sent using the RFC 5987 filename* parameter,
+// with an ASCII approximation in the plain filename parameter for older clients.
+func Attachment(filename, contentType string, data []byte) error {
+	h := make(http.Header)
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", contentDisposition(filename))
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	h.Set("X-Content-Type-Options", "nosniff")
+	return &response{code: http.StatusOK, header: h, body: data}
+}
+
+// contentDisposition returns a Content-Disposition header value
+// for an attachment named filename.
+func contentDisposition(filename string) string {
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+	filename = strings.ToValidUTF8(filename, "")
+	filename = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, filename)
+	if filename == "" {
+		return "attachment"
+	}
+
+	var b strings.Builder
+	b.WriteString(`attachment; filename="`)
+	ascii := true
+	for _, r := range filename {
+		switch {
+		case r >= utf8.RuneSelf:
+			ascii = false
+			b.WriteByte('_')
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	if ascii {
+		return b.String()
+	}
+
+	// RFC 5987 ext-value, percent-encoding everything outside attr-char.
+	const hex = "0123456789ABCDEF"
+	b.WriteString("; filename*=UTF-8''")
+	for i := 0; i < len(filename); i++ {
+		c := filename[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0xf])
+	}
+	return b.String()
+}
+
+// isAttrChar reports whether c is an RFC 5987 attr-char.
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
+
+// WithHTTPErrorCapture re-renders responses that appear to have been written by http.Error.
+//
+// Code that is unaware of hh, such as third-party middleware, may call http.Error directly,
+// producing plain text errors that are inconsistent with the rest of the application.
+// With this option, when a handler returns nil after writing a 4xx or 5xx response
+// that looks like the output of http.Error with the default status text (or of http.NotFound),
+// Wrap discards it and instead handles the error Error(code), as if the handler had returned it.
+// It passes through errorware and renders using wr's options, such as WithErrorTemplate.
+// Any other headers set by the handler are discarded, as with any error.
+//
+// Detection is heuristic: it matches on the body and on the headers that http.Error sets.
+// A deliberate plain text response that happens to match is also re-rendered.
+func WithHTTPErrorCapture() Option {
+	return func(wr *Wrapper) {
+		wr.captureHTTPError = true
+	}
+}
+
+// isHTTPError reports whether w's buffered response looks like
+// the output of http.Error(w, http.StatusText(code), code) for an error status code,
+// or of http.NotFound.
+func (w *bufferingResponseWriter) isHTTPError() bool {
+	if w.code < 400 || w.code > 599 {
+		return false
+	}
+	if w.header.Get("Content-Type") != "text/plain; charset=utf-8" || w.header.Get("X-Content-Type-Options") != "nosniff" {
+		return false
+	}
+	body := string(w.buffer.Bytes())
+	if w.code == http.StatusNotFound && body == "404 page not found\n" {
+		return true
+	}
+	text := http.StatusText(w.code)
+	return text != "" && body == text+"\n"
+}
+
+// RenderToWriter writes a textual representation of the response that Wrap would send for err to w:
+// a status line, such as "404 Not Found", followed by the header fields, a blank line, and the body.
+// It is useful for reusing HTTP errors in logs and in non-HTTP transports.
+//
+// As with Wrap, an err that does not resolve to an HTTPResponseError
+// is rendered as an opaque 500 (Internal Server Error); its text is not included.
+// There is no request available, so errors are always rendered using RenderHTTP.
+// A nil err is rendered as an empty 200 (OK).
+// RenderToWriter returns any error from writing to w.
+func RenderToWriter(w io.Writer, err error) error {
+	bufw := new(bufferingResponseWriter)
+	bufw.buffer = &bufw.buf
+	if err != nil {
+		re := asHTTPResponseError(err)
+		if re == nil {
+			re = &ResponseError{StatusCode: http.StatusInternalServerError, StatusText: http.StatusText(http.StatusInternalServerError)}
+		}
+		re.RenderHTTP(bufw)
+	}
+	code := http.StatusOK
+	if bufw.wroteCode {
+		code = bufw.code
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d %s\n", code, http.StatusText(code))
+	for _, k := range slices.Sorted(maps.Keys(bufw.header)) {
+		for _, v := range bufw.header[k] {
+			fmt.Fprintf(&b, "%s: %s\n", k, v)
+		}
+	}
+	b.WriteByte('\n')
+	b.Write(bufw.buffer.Bytes())
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// FromResponseStatus returns a ResponseError with the status code and status text of resp,
+// typically a response from an upstream server that failed.
+// This is the usual way for a gateway to pass an upstream failure on to its client.
+// resp's body is not included, lest it leak upstream details; see FromResponseBody.
+//
+// If resp's status code cannot be sent, such as a malformed code from a misbehaving server,
+// the error instead has status 502 (Bad Gateway).
+func FromResponseStatus(resp *http.Response) error {
+	code := resp.StatusCode
+	if code < 100 || code > 999 {
+		return Error(http.StatusBadGateway)
+	}
+	text, ok := strings.CutPrefix(resp.Status, strconv.Itoa(code)+" ")
+	if !ok || text == "" {
+		text = http.StatusText(code)
+	}
+	return &ResponseError{StatusCode: code, StatusText: text}
+}
+
+// FromResponseBody is like FromResponseStatus, but uses up to max bytes of resp's body
+// as the status text, in place of resp's status text.
+// It is intended for debugging: the body may contain upstream details unfit for clients.
+// If the body cannot be read, or is empty, FromResponseBody is equivalent to FromResponseStatus.
+// The caller remains responsible for closing resp.Body.
+func FromResponseBody(resp *http.Response, max int64) error {
+	err := FromResponseStatus(resp)
+	if resp.Body == nil {
+		return err
+	}
+	body, rerr := io.ReadAll(io.LimitReader(resp.Body, max))
+	if rerr != nil || len(body) == 0 {
+		return err
+	}
+	e := err.(*ResponseError)
+	e.StatusText = strings.TrimSuffix(string(body), "\n")
+	return e
+}
+
+// ErrorLegalReasons returns an HTTPResponseError with status 451 (Unavailable For Legal Reasons),
+// as specified by RFC 7725, with a Link header with relation type "blocked-by"
+// identifying blockedBy, the entity that implements the blockage, such as the service provider.
+// blockedBy must be an absolute URI, such as "https://example.com/legal".
+// If it is not, ErrorLegalReasons returns an error created with fmt.Errorf,
+// which results in a 500 (Internal Server Error) when returned from a wrapped handler.
+func ErrorLegalReasons(blockedBy string) error {
+	u, err := url.Parse(blockedBy)
+	if err != nil {
+		return fmt.Errorf("hh.ErrorLegalReasons: invalid blockedBy URI: %w", err)
+	}
+	if !u.IsAbs() || u.Host == "" && u.Opaque == "" {
+		return fmt.Errorf("hh.ErrorLegalReasons: blockedBy URI %q is not absolute", blockedBy)
+	}
+	h := make(http.Header)
+	h.Set("Link", formatLink(blockedBy, "blocked-by"))
+	return textResponse(http.StatusUnavailableForLegalReasons, h)
+}
@@ -0,0 +1,192 @@
+package hh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WithRetry retries failed handlers for idempotent requests.
+//
+// If a handler returns an error for which shouldRetry returns true,
+// and the request method is idempotent (GET, HEAD, OPTIONS, TRACE, PUT, or DELETE),
+// the handler's buffered output is discarded and the handler is called again,
+// up to max additional times.
+// If shouldRetry is nil, every error is retried.
+// Since nothing has been sent to the client, the client sees only the final attempt.
+// Requests with non-idempotent methods are never retried.
+// Retries stop early if the request's context is done.
+// Errorware runs once, on the error from the final attempt.
+// Any time limit set by TimeoutHandler or WithTimeouts applies to each attempt separately.
+//
+// A request body can only be read once.
+// Requests with a body are retried only if the body can be re-created using Request.GetBody,
+// which requests received by a server normally lack; see WithRequestBodyBuffer.
+// Retried handlers receive a shallow copy of the request with a fresh body.
+func WithRetry(max int, shouldRetry func(error) bool) Option {
+	return func(wr *Wrapper) {
+		wr.retryMax = max
+		wr.shouldRetry = shouldRetry
+	}
+}
+
+// callRetry is like callOnce, but retries as configured by WithRetry.
+func (wr *Wrapper) callRetry(h HandlerFunc, r *http.Request, dst http.ResponseWriter) (*bufferingResponseWriter, error) {
+	if wr.maxBodyBuffer > 0 && wr.retryMax > 0 && idempotent(r.Method) {
+		r = bufferBody(r, wr.maxBodyBuffer)
+	}
+	bufw, err := wr.callOnce(h, r, dst)
+	for i := 0; i < wr.retryMax && err != nil && !bufw.passThrough && (wr.shouldRetry == nil || wr.shouldRetry(err)); i++ {
+		if r.Context().Err() != nil {
+			break
+		}
+		rr, ok := retryRequest(r)
+		if !ok {
+			break
+		}
+		bufw.buffer.Reset()
+		bufw, err = wr.callOnce(h, rr, dst)
+	}
+	return bufw, err
+}
+
+// retryRequest returns a request with which to retry r, if it is safe to do so.
+func retryRequest(r *http.Request) (*http.Request, bool) {
+	if !idempotent(r.Method) {
+		return nil, false
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return r, true
+	}
+	if r.GetBody == nil {
+		return nil, false
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	rr := r.WithContext(r.Context())
+	rr.Body = body
+	return rr, true
+}
+
+// idempotent reports whether requests with the given method may safely be retried.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// WithRequestBodyBuffer makes request bodies of up to max bytes re-readable,
+// so that requests with bodies can be retried by WithRetry.
+//
+// Before calling the handler, Wrap reads the body into memory,
+// and the handler receives a shallow copy of the request
+// whose Body reads from memory and whose GetBody returns a fresh copy.
+// If the body is longer than max bytes, or reading it fails,
+// the handler instead receives a body that yields the bytes already read
+// followed by the rest of the original body (or the read error),
+// and the request is not retried.
+//
+// Bodies are only buffered when WithRetry is also in effect,
+// and only for requests with idempotent methods, since other requests are never retried.
+func WithRequestBodyBuffer(max int64) Option {
+	return func(wr *Wrapper) {
+		wr.maxBodyBuffer = max
+	}
+}
+
+// bufferBody implements WithRequestBodyBuffer.
+func bufferBody(r *http.Request, max int64) *http.Request {
+	if r.Body == nil || r.Body == http.NoBody || r.GetBody != nil || r.ContentLength > max {
+		return r
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, max+1))
+	rr := r.WithContext(r.Context())
+	if err != nil || int64(len(buf)) > max {
+		rest := r.Body
+		if err != nil {
+			rest = io.NopCloser(errReader{err})
+		}
+		rr.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), rest), r.Body}
+		return rr
+	}
+	rr.Body = io.NopCloser(bytes.NewReader(buf))
+	rr.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	return rr
+}
+
+// An errReader is an io.Reader that always fails with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// A RetryableError is an HTTPResponseError that tells clients whether and when to retry.
+//
+// It renders as a response with status StatusCode and a JSON body such as
+//
+//	{"error":"Service Unavailable","retryable":true,"retry_after":30}
+//
+// where error is the default status text and retry_after is RetryAfter in whole seconds, rounded up.
+// If RetryAfter is positive, the Retry-After header is also set, and retry_after is included;
+// otherwise both are omitted.
+type RetryableError struct {
+	StatusCode int           // the HTTP status code to respond with
+	Retryable  bool          // whether the client may retry the request
+	RetryAfter time.Duration // how long the client should wait before retrying; 0 for no suggestion
+}
+
+var _ HTTPResponseError = (*RetryableError)(nil)
+
+func (e *RetryableError) Error() string {
+	s := fmt.Sprintf("%d: %v", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Retryable {
+		s += " (retryable)"
+	}
+	return s
+}
+
+func (e *RetryableError) RenderHTTP(w http.ResponseWriter) {
+	body := struct {
+		Error      string `json:"error"`
+		Retryable  bool   `json:"retryable"`
+		RetryAfter int64  `json:"retry_after,omitempty"`
+	}{
+		Error:     http.StatusText(e.StatusCode),
+		Retryable: e.Retryable,
+	}
+	h := w.Header()
+	if e.RetryAfter > 0 {
+		body.RetryAfter = int64((e.RetryAfter + time.Second - 1) / time.Second)
+		h.Set("Retry-After", strconv.FormatInt(body.RetryAfter, 10))
+	}
+	buf, _ := json.Marshal(body) // cannot fail
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.StatusCode)
+	_, _ = w.Write(buf)
+}
+
+// RetryAfter returns a ResponseError with status statusCode and its default status text,
+// with a Retry-After header of d in whole seconds, rounded up.
+// It is intended for 429 (Too Many Requests) and 503 (Service Unavailable) responses.
+// See also RetryableError, which also tells clients in the body.
+func RetryAfter(statusCode int, d time.Duration) error {
+	secs := int64((max(d, 0) + time.Second - 1) / time.Second)
+	return &ResponseError{
+		StatusCode: statusCode,
+		StatusText: http.StatusText(statusCode),
+		Header:     http.Header{"Retry-After": {strconv.FormatInt(secs, 10)}},
+	}
+}
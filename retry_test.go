@@ -0,0 +1,79 @@
+package hh
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRetry(t *testing.T) {
+	transient := errors.New("transient")
+	tests := []struct {
+		name        string
+		max         int
+		shouldRetry func(error) bool
+		wantCode    int
+		wantCalls   int
+	}{
+		{"nil shouldRetry", 2, nil, http.StatusOK, 3},
+		{"shouldRetry", 2, func(err error) bool { return errors.Is(err, transient) }, http.StatusOK, 3},
+		{"shouldRetry false", 2, func(error) bool { return false }, http.StatusInternalServerError, 1},
+		{"too few retries", 1, nil, http.StatusInternalServerError, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			h := func(w http.ResponseWriter, r *http.Request) error {
+				calls++
+				io.WriteString(w, "attempt")
+				if calls <= 2 {
+					return transient
+				}
+				io.WriteString(w, " succeeded")
+				return nil
+			}
+			rec := httptest.NewRecorder()
+			NewWrapper(WithRetry(tt.max, tt.shouldRetry)).Wrap(h)(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantCode == http.StatusOK && rec.Body.String() != "attempt succeeded" {
+				t.Errorf("body = %q, want only the final attempt's output", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequestBodyBuffer(t *testing.T) {
+	for _, method := range []string{"PUT", "POST", "PATCH"} {
+		t.Run(method, func(t *testing.T) {
+			var bodies []string
+			var buffered bool
+			h := func(w http.ResponseWriter, r *http.Request) error {
+				b, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(b))
+				buffered = r.GetBody != nil
+				return errors.New("transient")
+			}
+			wr := NewWrapper(WithRetry(1, nil), WithRequestBodyBuffer(64))
+			wr.Wrap(h)(httptest.NewRecorder(), httptest.NewRequest(method, "/", strings.NewReader("payload")))
+			if want := idempotent(method); buffered != want {
+				t.Errorf("body buffered = %v, want %v", buffered, want)
+			}
+			want := []string{"payload"}
+			if idempotent(method) {
+				want = append(want, "payload")
+			}
+			if !slices.Equal(bodies, want) {
+				t.Errorf("handler read bodies %q, want %q", bodies, want)
+			}
+		})
+	}
+}
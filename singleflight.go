@@ -0,0 +1,128 @@
+package hh
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// WithSingleFlight coalesces concurrent identical requests.
+//
+// key returns the identity of a request; requests with the same key are identical.
+// While a handler is running for a key, other requests with that key do not call the handler;
+// instead they wait for it to return and receive copies of its response:
+// status, headers (including cookies set using Cookies), and body.
+// If the handler returns an error, every waiting request handles that same error value,
+// each passing it through its own errorware; errors should therefore not be request-specific.
+// Requests for which key returns "" are never coalesced.
+// The key should include everything that affects the response,
+// typically at least the method and URL, and any headers the response varies on.
+//
+// Only requests that arrive while the handler is running are coalesced;
+// nothing is cached afterwards, and Cache-Control and other headers are replayed exactly as written.
+// Waiters share the outcome of the first request, including the effects of its context,
+// so a cancellation or time limit on that request is seen as an error by all of them.
+// Use it only for idempotent, expensive handlers, typically GETs.
+//
+// Each call to Wrap creates a separate group of keys.
+func WithSingleFlight(key func(*http.Request) string) Option {
+	return func(wr *Wrapper) {
+		wr.flightKey = key
+	}
+}
+
+// A flight is a single execution of a handler, shared by all requests with the same key.
+type flight struct {
+	done chan struct{} // closed when bufw and err are set
+	bufw *bufferingResponseWriter
+	err  error
+	refs int // requests sharing f that have not yet replayed it; guarded by the group's mutex
+}
+
+// singleFlight implements WithSingleFlight.
+func (wr *Wrapper) singleFlight(h HandlerFunc) HandlerFunc {
+	key := wr.flightKey
+	var (
+		mu      sync.Mutex
+		flights = make(map[string]*flight)
+	)
+	return func(w http.ResponseWriter, r *http.Request) error {
+		k := key(r)
+		if k == "" {
+			return h(w, r)
+		}
+		mu.Lock()
+		f, ok := flights[k]
+		if !ok {
+			f = &flight{done: make(chan struct{})}
+			flights[k] = f
+		}
+		f.refs++
+		mu.Unlock()
+		if !ok {
+			f.run(wr, h, r, func() {
+				mu.Lock()
+				delete(flights, k)
+				mu.Unlock()
+			})
+		}
+		<-f.done
+		f.replay(w)
+		// f is no longer in flights, so once every request has replayed it, its buffer is unused.
+		mu.Lock()
+		f.refs--
+		last := f.refs == 0
+		mu.Unlock()
+		if last {
+			f.bufw.buffer.Reset()
+		}
+		return f.err
+	}
+}
+
+// run calls h with r, recording its response in f.
+// A panic in h is recorded as an error shared by all requests, as configured by wr.
+// run calls forget once no further requests should join f.
+func (f *flight) run(wr *Wrapper, h HandlerFunc, r *http.Request, forget func()) {
+	bufw := wr.newBufferingResponseWriter(r, nil) // no pass-through: the response is shared
+	f.bufw = bufw
+	defer func() {
+		forget()
+		close(f.done)
+	}()
+	defer wr.recoverPanic(&f.err)
+	f.err = h(bufw, r)
+	bufw.checkLateHeader()
+	// Cookies are applied here, once, rather than by each replay, which run concurrently.
+	bufw.applyCookies()
+	if f.err == nil {
+		f.err = bufw.err
+	}
+}
+
+// replay writes a copy of f's response to w.
+func (f *flight) replay(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range f.bufw.header {
+		h[k] = slices.Clone(v)
+	}
+	if f.bufw.noCache {
+		if bufw := bufferOf(w); bufw != nil {
+			bufw.noCache = true
+		}
+	}
+	if f.bufw.wroteCode {
+		w.WriteHeader(f.bufw.code)
+	}
+	if f.bufw.buffer.Len() > 0 {
+		_, _ = w.Write(f.bufw.buffer.Bytes())
+	}
+	if len(f.bufw.trailer) > 0 {
+		// Set after the body, so that w records them as trailers.
+		// Only if there are any: after the body, w treats a call to Header as a late change.
+		h = w.Header()
+		for k, v := range f.bufw.trailer {
+			h[k] = slices.Clone(v)
+		}
+	}
+}
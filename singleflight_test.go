@@ -0,0 +1,102 @@
+package hh
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func byPath(r *http.Request) string { return r.URL.Path }
+
+func TestSingleFlightJSONNilAsEmpty(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		return WriteJSON(w, http.StatusOK, []int(nil))
+	}
+	rec := httptest.NewRecorder()
+	NewWrapper(WithJSONNilAsEmpty(), WithSingleFlight(byPath)).Wrap(h)(rec, httptest.NewRequest("GET", "/", nil))
+	if got := rec.Body.String(); got != "[]" {
+		t.Errorf("body = %q, want %q", got, "[]")
+	}
+}
+
+// resetBuffer is a Buffer that records whether it has been Reset.
+type resetBuffer struct {
+	stringBuffer
+	resets int
+}
+
+func (b *resetBuffer) Reset() {
+	b.resets++
+	b.stringBuffer.Reset()
+}
+
+func TestSingleFlightBufferFactory(t *testing.T) {
+	var bufs []*resetBuffer
+	wr := NewWrapper(
+		WithBufferFactory(func() Buffer {
+			b := new(resetBuffer)
+			bufs = append(bufs, b)
+			return b
+		}),
+		WithSingleFlight(byPath),
+	)
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		w.Write([]byte("shared"))
+		return nil
+	}
+	rec := httptest.NewRecorder()
+	wr.Wrap(h)(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "shared" {
+		t.Errorf("body = %q, want %q", rec.Body.String(), "shared")
+	}
+	// One buffer for the response being sent, and one for the flight; both are finished with.
+	if len(bufs) != 2 {
+		t.Fatalf("factory called %d times, want 2", len(bufs))
+	}
+	for i, b := range bufs {
+		if b.resets != 1 {
+			t.Errorf("buffer %d Reset %d times, want 1", i, b.resets)
+		}
+	}
+}
+
+func TestSingleFlightCache(t *testing.T) {
+	calls := 0
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		calls++
+		w.Write([]byte("shared"))
+		return nil
+	}
+	key := func(r *http.Request) (string, bool) { return r.URL.Path, true }
+	wrapped := NewWrapper(WithResponseCache(time.Minute, 0, key), WithSingleFlight(byPath)).Wrap(h)
+	for range 2 {
+		rec := httptest.NewRecorder()
+		wrapped(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Body.String() != "shared" {
+			t.Errorf("body = %q, want %q", rec.Body.String(), "shared")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1: the response was not cached", calls)
+	}
+}
+
+func TestSingleFlightDoNotCache(t *testing.T) {
+	calls := 0
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		calls++
+		DoNotCache(w)
+		w.Header().Set("Cache-Control", "max-age=60") // DoNotCache holds even so
+		w.Write([]byte("private"))
+		return nil
+	}
+	key := func(r *http.Request) (string, bool) { return r.URL.Path, true }
+	wrapped := NewWrapper(WithResponseCache(time.Minute, 0, key), WithSingleFlight(byPath)).Wrap(h)
+	for range 2 {
+		wrapped(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2: a DoNotCache response was cached", calls)
+	}
+}
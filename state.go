@@ -0,0 +1,91 @@
+package hh
+
+import (
+	"context"
+	"net/http"
+)
+
+// stateKey is the context key for a request's *requestState.
+type stateKey struct{}
+
+// requestState is per-request information that Wrap makes available
+// to handlers and errorware through the request's context.
+type requestState struct {
+	route     string      // see WithRouteName
+	requestID string      // see WithRequestID
+	header    http.Header // see ResponseHeader; nil until first use
+}
+
+// stateOf returns r's requestState, or nil if it has none.
+func stateOf(r *http.Request) *requestState {
+	st, _ := r.Context().Value(stateKey{}).(*requestState)
+	return st
+}
+
+// attachState returns r with a new requestState for wr attached to its context.
+func (wr *Wrapper) attachState(r *http.Request) (*http.Request, *requestState) {
+	st := &requestState{route: wr.routeName}
+	if wr.requestIDHeader != "" {
+		st.requestID = wr.requestID(r)
+	}
+	return r.WithContext(context.WithValue(r.Context(), stateKey{}, st)), st
+}
+
+// fork returns a copy of st, with its own header, for a handler that may be abandoned.
+func (st *requestState) fork() *requestState {
+	c := *st
+	c.header = st.header.Clone()
+	return &c
+}
+
+// applyHeader adds the headers set using ResponseHeader, if any, to h.
+func (st *requestState) applyHeader(h http.Header) {
+	for k, v := range st.header {
+		h[k] = v
+	}
+}
+
+// WithRouteName names the route served by wrapped handlers.
+// The name is available to handlers and errorware using RouteName.
+// This is useful for labeling logs and metrics,
+// particularly for routes with path wildcards, whose paths vary.
+//
+// A route name is usually specific to a single handler; see Wrapper.With.
+func WithRouteName(name string) Option {
+	return func(wr *Wrapper) {
+		wr.routeName = name
+	}
+}
+
+// RouteName returns the route name for r set by WithRouteName,
+// or the empty string if there is none.
+func RouteName(r *http.Request) string {
+	if st := stateOf(r); st != nil {
+		return st.route
+	}
+	return ""
+}
+
+// ResponseHeader returns a header map whose contents are added to the response to r,
+// whether it is a success or an error response.
+// It allows errorware, which does not have access to the response writer,
+// to set headers such as a correlation ID on every response, whatever the error.
+// Headers set by a handler on its http.ResponseWriter are discarded when it fails;
+// headers set here are not.
+//
+// The headers are added before the response is written,
+// so headers set by the handler's successful response, or by an error as it renders, take precedence.
+// With WrapStreaming, they are added only if the handler has not yet written anything.
+// Headers set by a handler abandoned because of a time limit (see TimeoutHandler) are discarded,
+// like its other output.
+// If r is not being served by Wrap, changes to the returned header are discarded.
+func ResponseHeader(r *http.Request) http.Header {
+	st := stateOf(r)
+	if st == nil {
+		return make(http.Header)
+	}
+	if st.header == nil {
+		st.header = make(http.Header)
+	}
+	return st.header
+}
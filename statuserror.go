@@ -0,0 +1,85 @@
+package hh
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// A StatusError is an HTTPResponseError that renders in the style of gRPC's google.rpc.Status,
+// for clients that expect gRPC-style errors, as a JSON object with Content-Type application/json:
+//
+//	{"code": 5, "message": "no such user", "details": [...]}
+//
+// Code is a gRPC status code, such as 5 for NOT_FOUND.
+// The HTTP status is derived from Code using the standard gRPC mapping; see StatusCode.
+type StatusError struct {
+	Code    int    `json:"code"`              // the gRPC status code
+	Message string `json:"message,omitempty"` // a developer-facing error message
+	Details []any  `json:"details,omitempty"` // additional structured details
+}
+
+var _ HTTPResponseError = (*StatusError)(nil)
+
+// grpcHTTPStatus maps gRPC status codes to HTTP status codes,
+// matching the mapping used by gRPC-HTTP transcoding.
+var grpcHTTPStatus = [...]int{
+	0:  http.StatusOK,                  // OK
+	1:  499,                            // CANCELLED: client closed request
+	2:  http.StatusInternalServerError, // UNKNOWN
+	3:  http.StatusBadRequest,          // INVALID_ARGUMENT
+	4:  http.StatusGatewayTimeout,      // DEADLINE_EXCEEDED
+	5:  http.StatusNotFound,            // NOT_FOUND
+	6:  http.StatusConflict,            // ALREADY_EXISTS
+	7:  http.StatusForbidden,           // PERMISSION_DENIED
+	8:  http.StatusTooManyRequests,     // RESOURCE_EXHAUSTED
+	9:  http.StatusBadRequest,          // FAILED_PRECONDITION
+	10: http.StatusConflict,            // ABORTED
+	11: http.StatusBadRequest,          // OUT_OF_RANGE
+	12: http.StatusNotImplemented,      // UNIMPLEMENTED
+	13: http.StatusInternalServerError, // INTERNAL
+	14: http.StatusServiceUnavailable,  // UNAVAILABLE
+	15: http.StatusInternalServerError, // DATA_LOSS
+	16: http.StatusUnauthorized,        // UNAUTHENTICATED
+}
+
+// StatusCode returns the HTTP status code with which e renders.
+// Unrecognized gRPC codes map to 500 (Internal Server Error),
+// as does 0 (OK), since a StatusError is an error.
+func (e *StatusError) StatusCode() int {
+	if e.Code <= 0 || e.Code >= len(grpcHTTPStatus) {
+		return http.StatusInternalServerError
+	}
+	return grpcHTTPStatus[e.Code]
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d: %v (code %d)", e.StatusCode(), http.StatusText(e.StatusCode()), e.Code)
+	}
+	return fmt.Sprintf("%d: %v (code %d)", e.StatusCode(), e.Message, e.Code)
+}
+
+// RenderHTTP renders e.
+// Details that cannot be JSON-encoded are replaced by an object
+// describing the encoding failure, so that the rest of the response is still sent.
+func (e *StatusError) RenderHTTP(w http.ResponseWriter) {
+	doc := StatusError{Code: e.Code, Message: e.Message}
+	for _, d := range e.Details {
+		buf, err := json.Marshal(d)
+		if err != nil {
+			buf, _ = json.Marshal(map[string]string{"error": "hh: detail cannot be encoded: " + err.Error()}) // cannot fail
+		}
+		doc.Details = append(doc.Details, json.RawMessage(buf))
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		// unreachable: every detail has been encoded already
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.StatusCode())
+	_, _ = w.Write(buf)
+}
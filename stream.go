@@ -0,0 +1,113 @@
+package hh
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// WrapStreaming converts h to a standard http.HandlerFunc, like Wrap, but without buffering.
+// It is intended for handlers that stream, such as server-sent events, or that hijack the connection,
+// such as WebSocket upgrades.
+//
+// h writes directly to the underlying http.ResponseWriter.
+// The writer passed to h implements http.Flusher, http.Hijacker, and http.Pusher,
+// forwarding to the underlying writer; if the underlying writer does not support an operation,
+// Hijack and Push return http.ErrNotSupported and Flush does nothing.
+//
+// Errors returned by h are passed through the errorware, as with Wrap.
+// If h has not yet written anything (called WriteHeader, Write, or Flush, or hijacked the connection),
+// the error renders the response, as with Wrap,
+// except that any headers h set have already been set on the response and are not discarded.
+// Otherwise, the response is already underway, and the error cannot be rendered:
+// the client receives whatever h wrote, and the error is only reported,
+// to WithAfterRequest and WithAsyncObserver functions.
+//
+// Options that depend on buffering, such as WithRetry, TimeoutHandler, and WithEnforceJSON,
+// have no effect on streaming handlers.
+func WrapStreaming(h HandlerFunc, errorware ...func(*http.Request, error) error) http.HandlerFunc {
+	return defaultWrapper.WrapStreaming(h, errorware...)
+}
+
+// WrapStreaming is like the package-level WrapStreaming, modified by wr's options.
+func (wr *Wrapper) WrapStreaming(h HandlerFunc, errorware ...func(*http.Request, error) error) http.HandlerFunc {
+	if wr.concurrency > 0 {
+		h = limitConcurrency(h, wr.concurrency, wr.onReject)
+	}
+	if wr.requestGuards != nil {
+		h = guardRequests(h, wr.requestGuards)
+	}
+	return wr.handlerFunc(h, errorware, wr.serveStreaming)
+}
+
+// serveStreaming serves r using h, without buffering.
+func (wr *Wrapper) serveStreaming(w http.ResponseWriter, r *http.Request, h HandlerFunc, errorware []func(*http.Request, error) error) {
+	if wr.metrics != nil {
+		wr.metrics.requests.Add(1)
+		defer wr.metrics.countPanic()
+	}
+	r, st := wr.attachState(r)
+	sw := &streamingWriter{outputWriter: *wr.newOutputWriter(w, r)}
+	err := wr.callStreaming(h, sw, r)
+	err = wr.applyErrorware(r, err, errorware)
+	handled := errors.Is(err, ErrHandled)
+	if !sw.hijacked && !handled && sw.code == 0 {
+		st.applyHeader(sw.Header())
+	}
+	switch {
+	case sw.hijacked:
+		// the connection belongs to h
+	case handled:
+		wr.finish(r, &sw.outputWriter, err)
+		return
+	case err != nil && sw.code == 0:
+		wr.render(&sw.outputWriter, r, err)
+	case sw.code == 0:
+		sw.WriteHeader(http.StatusOK)
+	}
+	if !sw.hijacked {
+		sw.writeTrailers()
+	}
+	wr.finish(r, &sw.outputWriter, err)
+}
+
+// callStreaming calls h, recovering any panic; see PanicError.
+func (wr *Wrapper) callStreaming(h HandlerFunc, w http.ResponseWriter, r *http.Request) (err error) {
+	defer wr.recoverPanic(&err)
+	return h(w, r)
+}
+
+// A streamingWriter is the http.ResponseWriter passed to handlers wrapped by WrapStreaming.
+type streamingWriter struct {
+	outputWriter
+	hijacked bool
+}
+
+var (
+	_ http.Flusher  = (*streamingWriter)(nil)
+	_ http.Hijacker = (*streamingWriter)(nil)
+	_ http.Pusher   = (*streamingWriter)(nil)
+)
+
+func (w *streamingWriter) Flush() {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *streamingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w *streamingWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
@@ -0,0 +1,91 @@
+package hh
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrapStreamingError(t *testing.T) {
+	failure := ErrNotFound
+	tests := []struct {
+		name     string
+		write    func(w http.ResponseWriter) // what the handler writes before failing
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "nothing written",
+			write:    func(w http.ResponseWriter) {},
+			wantCode: http.StatusNotFound, wantBody: "Not Found\n",
+		},
+		{
+			name:     "header only",
+			write:    func(w http.ResponseWriter) { w.WriteHeader(http.StatusAccepted) },
+			wantCode: http.StatusAccepted, wantBody: "",
+		},
+		{
+			name:     "partial body",
+			write:    func(w http.ResponseWriter) { io.WriteString(w, "data: 1\n\n") },
+			wantCode: http.StatusOK, wantBody: "data: 1\n\n",
+		},
+		{
+			name:     "flushed",
+			write:    func(w http.ResponseWriter) { w.(http.Flusher).Flush() },
+			wantCode: http.StatusOK, wantBody: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var results []Result
+			var errorwareSaw error
+			wr := NewWrapper(WithAfterRequest(func(r *http.Request, res Result) {
+				results = append(results, res)
+			}))
+			h := func(w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("X-Handler", "1")
+				tt.write(w)
+				return failure
+			}
+			errorware := func(r *http.Request, err error) error {
+				errorwareSaw = err
+				return err
+			}
+			rec := httptest.NewRecorder()
+			wr.WrapStreaming(h, errorware)(rec, httptest.NewRequest("GET", "/", nil))
+
+			if rec.Code != tt.wantCode || rec.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+			}
+			// Unlike Wrap, headers set before the error are not discarded.
+			if rec.Header().Get("X-Handler") != "1" {
+				t.Errorf("X-Handler header missing")
+			}
+			// Whether or not it could be rendered, the error is passed through errorware and reported.
+			if !errors.Is(errorwareSaw, failure) {
+				t.Errorf("errorware saw %v, want %v", errorwareSaw, failure)
+			}
+			if len(results) != 1 || results[0].StatusCode != tt.wantCode || !errors.Is(results[0].Err, failure) {
+				t.Errorf("WithAfterRequest saw %+v, want status %d and error %v", results, tt.wantCode, failure)
+			}
+		})
+	}
+}
+
+func TestWrapStreamingFlush(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		io.WriteString(w, "event")
+		w.(http.Flusher).Flush()
+		return nil
+	}
+	rec := httptest.NewRecorder()
+	WrapStreaming(h)(rec, httptest.NewRequest("GET", "/", nil))
+	if !rec.Flushed {
+		t.Errorf("Flush was not forwarded to the underlying writer")
+	}
+	if rec.Body.String() != "event" {
+		t.Errorf("body = %q, want %q", rec.Body.String(), "event")
+	}
+}
@@ -0,0 +1,62 @@
+package hh
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+// ErrorTemplateData is the data passed to templates registered with WithErrorTemplate.
+type ErrorTemplateData struct {
+	StatusCode int    // the HTTP status code of the response
+	StatusText string // the text that accompanies the status code
+}
+
+// WithErrorTemplate renders all error responses in a status class using tmpl.
+// The class is 400 (for all 4xx responses) or 500 (for all 5xx responses).
+// WithErrorTemplate panics for any other class.
+// The template is executed with an ErrorTemplateData,
+// and the response has Content-Type text/html.
+//
+// Templates apply only to errors that do not render themselves:
+// errors that resolve to a *ResponseError (including the helpers Error, ErrorText, and so on),
+// and errors that are not HTTPResponseErrors at all, which are converted to 500s.
+// A *ResponseError whose Header sets a Content-Type, such as one from ErrorJSON, also renders itself.
+// Custom HTTPResponseError implementations always take precedence and render themselves.
+// If executing tmpl fails, the error is rendered as if no template were registered.
+func WithErrorTemplate(class int, tmpl *template.Template) Option {
+	if class != 400 && class != 500 {
+		panic("hh.WithErrorTemplate: class must be 400 or 500, got " + strconv.Itoa(class))
+	}
+	return func(wr *Wrapper) {
+		if wr.templates == nil {
+			wr.templates = make(map[int]*template.Template)
+		}
+		wr.templates[class/100] = tmpl
+	}
+}
+
+// renderTemplate renders e using a template registered with WithErrorTemplate.
+// It reports whether it wrote a response.
+func (wr *Wrapper) renderTemplate(w http.ResponseWriter, e *ResponseError) bool {
+	tmpl := wr.templates[e.StatusCode/100]
+	if tmpl == nil || e.Header.Get("Content-Type") != "" {
+		// no template, or e has its own format, such as JSON
+		return false
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ErrorTemplateData{StatusCode: e.StatusCode, StatusText: e.StatusText}); err != nil {
+		return false
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	for k, v := range e.Header {
+		h[k] = v
+	}
+	w.WriteHeader(e.StatusCode)
+	_, _ = w.Write(buf.Bytes())
+	return true
+}
@@ -0,0 +1,127 @@
+package hh
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// TimeoutHandler is like Wrap, but limits h to running for duration dt.
+// It is an alternative to http.TimeoutHandler for wrapped handlers.
+//
+// http.TimeoutHandler writes its 503 directly to the client, bypassing Wrap's error handling.
+// TimeoutHandler instead treats a timeout like any other handler error:
+// if h has not returned after dt, its buffered output is discarded, even if h is in the middle of writing it,
+// and an error that resolves to ErrServiceUnavailable is passed through the errorware and rendered.
+// To render a different error, use WithTimeoutError.
+// That error also wraps the context's error, typically context.DeadlineExceeded.
+//
+// h runs with a request whose context is canceled after dt.
+// If the request's own context is canceled first, typically because the client has gone away,
+// h is abandoned in the same way, but the error's text says so instead of reporting a timeout.
+// h keeps running after the timeout until it returns;
+// it should respect its context to avoid wasting resources.
+// Output from h after the timeout is silently dropped.
+// If h panics, the panic is recovered as usual (see PanicError),
+// except that a panic with the value http.ErrAbortHandler is propagated to the goroutine serving the request.
+func TimeoutHandler(h HandlerFunc, dt time.Duration, errorware ...func(*http.Request, error) error) http.HandlerFunc {
+	return defaultWrapper.TimeoutHandler(h, dt, errorware...)
+}
+
+// TimeoutHandler is like the package-level TimeoutHandler, using wr to wrap h.
+func (wr *Wrapper) TimeoutHandler(h HandlerFunc, dt time.Duration, errorware ...func(*http.Request, error) error) http.HandlerFunc {
+	wrt := *wr
+	wrt.timeout = dt
+	wrt.timeoutError = ErrServiceUnavailable
+	return wrt.Wrap(h, errorware...)
+}
+
+// WithTimeouts sets graduated time limits for handlers.
+//
+// If a handler is still running after soft, onSoft is called with the request,
+// in its own goroutine; this is a good place to log slow requests.
+// The handler is not interrupted.
+// If soft is not positive, or onSoft is nil, there is no soft limit.
+//
+// If a handler is still running after hard, it is abandoned, as with TimeoutHandler,
+// except that the error resolves to ErrGatewayTimeout.
+// If hard is not positive, there is no hard limit.
+//
+// Both limits are measured from the time Wrap starts handling the request.
+// If soft is not less than hard, the soft limit is ignored:
+// a request that exceeds it would already have been abandoned.
+func WithTimeouts(soft, hard time.Duration, onSoft func(*http.Request)) Option {
+	return func(wr *Wrapper) {
+		wr.softTimeout = soft
+		wr.onSoftTimeout = onSoft
+		wr.timeout = hard
+		wr.timeoutError = ErrGatewayTimeout
+	}
+}
+
+// WithTimeoutError sets the error for a handler abandoned because of a time limit,
+// set by TimeoutHandler or WithTimeouts, in place of ErrServiceUnavailable or ErrGatewayTimeout.
+// It is typically an HTTPResponseError, such as a custom error page or ErrorJSON.
+// As with the default, whatever the handler wrote before the limit is discarded,
+// and the error wraps err and the context's error, and passes through errorware before rendering.
+func WithTimeoutError(err error) Option {
+	return func(wr *Wrapper) {
+		wr.customTimeoutError = err
+	}
+}
+
+// callTimeout is like call, but abandons h if it runs longer than wr.timeout.
+func (wr *Wrapper) callTimeout(h HandlerFunc, r *http.Request) (*bufferingResponseWriter, error) {
+	dt := wr.timeout
+	timeoutError := wr.timeoutError
+	if wr.customTimeoutError != nil {
+		timeoutError = wr.customTimeoutError
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), dt)
+	defer cancel()
+	// h may be abandoned while still running, so it gets its own copy of the request state,
+	// whose headers (see ResponseHeader) are kept only if h returns in time.
+	st := stateOf(r)
+	var hst *requestState
+	if st != nil {
+		hst = st.fork()
+		ctx = context.WithValue(ctx, stateKey{}, hst)
+	}
+
+	type result struct {
+		bufw  *bufferingResponseWriter
+		err   error
+		panic any
+	}
+	done := make(chan result, 1) // buffered, so that an abandoned h does not leak its goroutine
+	go func() {
+		var res result
+		defer func() {
+			if p := recover(); p != nil {
+				res.panic = p
+			}
+			done <- res
+		}()
+		res.bufw, res.err = wr.call(h, r.WithContext(ctx), nil) // no pass-through: h may be abandoned
+	}()
+
+	select {
+	case res := <-done:
+		if hst != nil {
+			st.header = hst.header
+		}
+		if res.panic != nil {
+			panic(res.panic)
+		}
+		return res.bufw, res.err
+	case <-ctx.Done():
+		// The abandoned handler keeps its own buffer; start over with an empty one.
+		bufw := wr.newBufferingResponseWriter(r, nil)
+		if err := r.Context().Err(); err != nil {
+			// Not a timeout: the request was canceled, probably because the client went away.
+			return bufw, fmt.Errorf("%w: request canceled before handler returned: %w", timeoutError, err)
+		}
+		return bufw, fmt.Errorf("%w: handler timed out after %v: %w", timeoutError, dt, ctx.Err())
+	}
+}
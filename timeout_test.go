@@ -0,0 +1,97 @@
+package hh
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutDiscardsPartialOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantCode int
+		wantBody string
+		wantCT   string
+	}{
+		{"default", nil, http.StatusGatewayTimeout, "Gateway Timeout\n", "text/plain; charset=utf-8"},
+		{
+			"WithTimeoutError",
+			[]Option{WithTimeoutError(ErrorJSON(http.StatusGatewayTimeout, map[string]string{"error": "timeout"}))},
+			http.StatusGatewayTimeout, `{"error":"timeout"}`, "application/json; charset=utf-8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finished := make(chan struct{})
+			h := func(w http.ResponseWriter, r *http.Request) error {
+				defer close(finished)
+				w.Header().Set("X-Partial", "1")
+				w.WriteHeader(http.StatusOK)
+				io.WriteString(w, "partial ")
+				<-r.Context().Done() // block past the deadline
+				io.WriteString(w, "and late")
+				return nil
+			}
+			opts := append([]Option{WithTimeouts(0, 10*time.Millisecond, nil)}, tt.opts...)
+			rec := httptest.NewRecorder()
+			NewWrapper(opts...).Wrap(h)(rec, httptest.NewRequest("GET", "/", nil))
+			<-finished
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != strings.TrimSpace(tt.wantBody) {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantCT {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantCT)
+			}
+			if rec.Header().Get("X-Partial") != "" {
+				t.Errorf("response has the abandoned handler's header")
+			}
+		})
+	}
+}
+
+func TestTimeoutResponseHeader(t *testing.T) {
+	finished := make(chan struct{})
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		defer close(finished)
+		ResponseHeader(r).Set("X-Early", "1") // discarded with the rest of the handler's output
+		time.Sleep(25 * time.Millisecond)
+		ResponseHeader(r).Set("X-Late", "1") // after the timeout; must not race with the response
+		return nil
+	}
+	errorware := func(r *http.Request, err error) error {
+		ResponseHeader(r).Set("X-Errorware", "1")
+		return err
+	}
+	rec := httptest.NewRecorder()
+	TimeoutHandler(h, 10*time.Millisecond, errorware)(rec, httptest.NewRequest("GET", "/", nil))
+	<-finished
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if rec.Header().Get("X-Early") != "" || rec.Header().Get("X-Late") != "" {
+		t.Errorf("response has headers set by the abandoned handler: %v", rec.Header())
+	}
+	if rec.Header().Get("X-Errorware") != "1" {
+		t.Errorf("response lacks the header set by errorware")
+	}
+
+	// A handler that returns in time keeps its headers.
+	h = func(w http.ResponseWriter, r *http.Request) error {
+		ResponseHeader(r).Set("X-Handler", "1")
+		return nil
+	}
+	rec = httptest.NewRecorder()
+	TimeoutHandler(h, time.Second)(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Header().Get("X-Handler") != "1" {
+		t.Errorf("response lacks the header set by the handler")
+	}
+}
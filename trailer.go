@@ -0,0 +1,43 @@
+package hh
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// WithDigestTrailer sends the SHA-256 digest of each response body in a trailer named name.
+//
+// The trailer is declared in the Trailer header, and its value is computed from the bytes
+// actually sent to the client, so it is correct for buffered responses, error responses,
+// responses that switch to pass-through mode (see WithMaxBufferSize), and streaming responses
+// (see WrapStreaming), whose digest cannot be known when the header is sent.
+// If name is "Digest", the value uses the RFC 3230 syntax, such as "SHA-256=<base64>".
+// Otherwise, it uses the RFC 9530 syntax, such as "sha-256=:<base64>:";
+// "Content-Digest" is the name RFC 9530 defines.
+// Responses to HEAD requests and hijacked connections have no trailer.
+//
+// Client support for trailers is limited.
+// HTTP/1.1 can only send trailers with chunked encoding,
+// so they are lost if the handler sets a Content-Length.
+// Many clients, including browsers' fetch API, and some proxies ignore or drop trailers.
+// A trailer is therefore best used as an optional integrity check by clients known to support it.
+func WithDigestTrailer(name string) Option {
+	return func(wr *Wrapper) {
+		wr.digestTrailer = http.CanonicalHeaderKey(name)
+	}
+}
+
+// writeTrailers sets the trailers declared by w.
+// It must be called after the body has been written.
+func (w *outputWriter) writeTrailers() {
+	if w.digest == nil || w.code == 0 {
+		return
+	}
+	sum := base64.StdEncoding.EncodeToString(w.digest.Sum(nil))
+	v := "sha-256=:" + sum + ":"
+	if strings.EqualFold(w.wr.digestTrailer, "Digest") {
+		v = "SHA-256=" + sum
+	}
+	w.Header().Set(w.wr.digestTrailer, v)
+}
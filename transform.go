@@ -0,0 +1,95 @@
+package hh
+
+import (
+	"net/http"
+	"slices"
+)
+
+// A BufferedResponse is a successful response buffered by Wrap, as seen by a response hook.
+// See WithResponseHook.
+type BufferedResponse struct {
+	StatusCode int         // the status code; 200 (OK) if the handler did not call WriteHeader
+	Header     http.Header // the response header; modifications are sent
+	Body       []byte      // the body; may be modified or replaced
+}
+
+// WithResponseHook calls fn on each successful response before it is sent,
+// allowing it to inspect or transform the response, for example to rewrite links or validate an upstream body.
+//
+// fn is called after the handler returns nil, before errorware runs.
+// Changes fn makes to resp are sent to the client.
+// resp.Body belongs to Wrap; fn must not retain it after returning.
+// If fn returns an error, the response is discarded,
+// and the error is handled exactly as if the handler had returned it:
+// it passes through errorware, and if it resolves to an HTTPResponseError,
+// such as Error(http.StatusBadGateway), it renders that response.
+//
+// Multiple WithResponseHook options are called in order;
+// a hook that returns an error stops the rest.
+// WithResponseHook adds a hook at TransformStage; see WithStagedResponseHook.
+func WithResponseHook(fn func(r *http.Request, resp *BufferedResponse) error) Option {
+	return WithStagedResponseHook(TransformStage, fn)
+}
+
+// A ResponseHookStage is a position in the sequence of response hooks.
+// Hooks run in order of stage, and within a stage in the order they were added.
+// The stages ensure that hooks whose order matters run in the right order,
+// however the options that add them are combined.
+type ResponseHookStage int
+
+const (
+	// TransformStage is for hooks that change the content of the body, such as rewriting links.
+	TransformStage ResponseHookStage = iota
+	// DigestStage is for hooks that compute values from the final content, such as an ETag or a checksum.
+	DigestStage
+	// EncodeStage is for hooks that encode the body for transfer, such as compression.
+	// They run last, so that DigestStage hooks see the content, not its encoding.
+	EncodeStage
+)
+
+// WithStagedResponseHook is like WithResponseHook, but adds fn at the given stage.
+func WithStagedResponseHook(stage ResponseHookStage, fn func(r *http.Request, resp *BufferedResponse) error) Option {
+	return func(wr *Wrapper) {
+		i := len(wr.responseHooks)
+		for i > 0 && wr.responseHooks[i-1].stage > stage {
+			i--
+		}
+		wr.responseHooks = slices.Insert(wr.responseHooks, i, responseHook{stage, fn})
+	}
+}
+
+// A responseHook is a hook added by WithStagedResponseHook.
+type responseHook struct {
+	stage ResponseHookStage
+	fn    func(*http.Request, *BufferedResponse) error
+}
+
+// runResponseHooks implements WithResponseHook.
+func (wr *Wrapper) runResponseHooks(r *http.Request, bufw *bufferingResponseWriter) error {
+	if bufw.header == nil {
+		bufw.header = make(http.Header)
+	}
+	resp := &BufferedResponse{StatusCode: http.StatusOK, Header: bufw.header, Body: bufw.buffer.Bytes()}
+	if bufw.wroteCode {
+		resp.StatusCode = bufw.code
+	}
+	for _, h := range wr.responseHooks {
+		if err := h.fn(r, resp); err != nil {
+			return err
+		}
+	}
+	bufw.header = resp.Header
+	if resp.StatusCode != http.StatusOK || bufw.wroteCode {
+		bufw.code = resp.StatusCode
+		bufw.wroteCode = true
+	}
+	if body := bufw.buffer.Bytes(); !sameBytes(body, resp.Body) {
+		bufw.replaceBody(resp.Body)
+	}
+	return nil
+}
+
+// sameBytes reports whether a and b are the same slice of memory.
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
@@ -0,0 +1,112 @@
+package hh
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResponseHookStages(t *testing.T) {
+	var order []string
+	hook := func(name string) func(*http.Request, *BufferedResponse) error {
+		return func(r *http.Request, resp *BufferedResponse) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+	wr := NewWrapper(
+		WithStagedResponseHook(EncodeStage, hook("encode")),
+		WithStagedResponseHook(DigestStage, hook("digest 1")),
+		WithResponseHook(hook("transform 1")),
+		WithStagedResponseHook(DigestStage, hook("digest 2")),
+		WithResponseHook(hook("transform 2")),
+	).With(WithStagedResponseHook(TransformStage, hook("transform 3")))
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		io.WriteString(w, "body")
+		return nil
+	}
+	wr.Wrap(h)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	want := []string{"transform 1", "transform 2", "transform 3", "digest 1", "digest 2", "encode"}
+	if !slices.Equal(order, want) {
+		t.Errorf("hooks ran in order %q, want %q", order, want)
+	}
+}
+
+func TestDigestBeforeGzip(t *testing.T) {
+	body := strings.Repeat("compress me, ", 100)
+	sum := sha256.Sum256([]byte(body))
+	wantDigest := hex.EncodeToString(sum[:])
+	digest := WithStagedResponseHook(DigestStage, func(r *http.Request, resp *BufferedResponse) error {
+		sum := sha256.Sum256(resp.Body)
+		resp.Header.Set("X-Digest", hex.EncodeToString(sum[:]))
+		return nil
+	})
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, body)
+		return nil
+	}
+	// Whichever order the options are given in, the digest is of the uncompressed body.
+	for name, opts := range map[string][]Option{
+		"gzip first":   {WithGzip(0), digest},
+		"digest first": {digest, WithGzip(0)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			NewWrapper(opts...).Wrap(h)(rec, r)
+			if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+				t.Fatalf("Content-Encoding = %q, want gzip", got)
+			}
+			if got := rec.Header().Get("X-Digest"); got != wantDigest {
+				t.Errorf("X-Digest = %s, want %s (of the uncompressed body)", got, wantDigest)
+			}
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := io.ReadAll(zr); string(got) != body {
+				t.Errorf("decompressed body = %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestResponseHookError(t *testing.T) {
+	var later bool
+	wr := NewWrapper(
+		WithResponseHook(func(r *http.Request, resp *BufferedResponse) error {
+			return Error(http.StatusBadGateway)
+		}),
+		WithResponseHook(func(r *http.Request, resp *BufferedResponse) error {
+			later = true
+			return nil
+		}),
+	)
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("X-Handler", "1")
+		io.WriteString(w, "upstream body")
+		return nil
+	}
+	rec := httptest.NewRecorder()
+	wr.Wrap(h)(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+	if got, want := rec.Body.String(), "Bad Gateway\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if rec.Header().Get("X-Handler") != "" {
+		t.Errorf("response has the discarded response's header")
+	}
+	if later {
+		t.Errorf("hook after the failing one was called")
+	}
+}
@@ -0,0 +1,372 @@
+package hh
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/netip"
+	"runtime"
+	"slices"
+	"time"
+)
+
+// A Wrapper is a configured Wrap.
+// Create one with NewWrapper.
+type Wrapper struct {
+	routeName       string        // see WithRouteName
+	requestIDHeader string        // see WithRequestID
+	newRequestID    func() string // see WithRequestID
+	newBuffer       func() Buffer // see WithBufferFactory
+
+	guard          *slog.Logger // see WithErrorwareGuard
+	emptyOnResolve bool         // see WithEmptyOnResolve
+	writerGuard    *slog.Logger // see WithWriterGuard
+
+	fallbackRender func(http.ResponseWriter, *http.Request, error) // see WithFallbackRenderer
+
+	templates     map[int]*template.Template    // status class (4 or 5) to template; see WithErrorTemplate
+	after         []func(*http.Request, Result) // see WithAfterRequest
+	async         []func(*http.Request, error)  // see WithAsyncObserver
+	responseHooks []responseHook                // sorted by stage; see WithStagedResponseHook
+	metrics       *expvarMetrics                // see WithExpvar
+	requestLog    *requestLog                   // see WithRequestLog
+
+	maxHeaders  int      // see WithMaxHeaders
+	concurrency int      // see WithConcurrencyLimit
+	onReject    error    // see WithConcurrencyLimit
+	drainer     *Drainer // see WithDrain
+
+	requestGuards *RequestGuards // see WithRequestGuards
+
+	flightKey func(*http.Request) string // see WithSingleFlight
+
+	cacheTTL time.Duration                      // see WithResponseCache
+	cacheMax int                                // see WithResponseCache
+	cacheKey func(*http.Request) (string, bool) // see WithResponseCache
+
+	oversizeLimit    int64                                 // see WithOversizeHook
+	onOversize       func(*http.Request, OversizeResponse) // see WithOversizeHook
+	captureHTTPError bool                                  // see WithHTTPErrorCapture
+	enforceJSON      bool                                  // see WithEnforceJSON
+	jsonNilAsEmpty   bool                                  // see WithJSONNilAsEmpty
+	setMissingJSON   bool                                  // see WithEnforceJSON
+	schemas          map[string]Schema                     // route name to schema; see WithResponseSchema
+
+	headers        http.Header  // see WithHeaders
+	cookieDefaults *http.Cookie // see WithCookieDefaults
+	digestTrailer  string       // see WithDigestTrailer
+
+	timeout            time.Duration       // see TimeoutHandler and WithTimeouts
+	timeoutError       error               // the error for a timeout; see TimeoutHandler and WithTimeouts
+	customTimeoutError error               // see WithTimeoutError
+	softTimeout        time.Duration       // see WithTimeouts
+	onSoftTimeout      func(*http.Request) // see WithTimeouts
+
+	retryMax    int              // see WithRetry
+	shouldRetry func(error) bool // see WithRetry
+
+	maxBodyBuffer int64 // see WithRequestBodyBuffer
+	maxBuffer     int64 // see WithMaxBufferSize
+
+	stackDepth  int                          // see WithPanicStackDepth
+	formatStack func([]runtime.Frame) []byte // see WithPanicStackFormatter
+
+	trustedProxies   []netip.Prefix // see WithTrustedProxies
+	internalNetworks []netip.Prefix // see WithInternalNetworks
+}
+
+// An Option configures a Wrapper.
+type Option func(*Wrapper)
+
+// NewWrapper returns a Wrapper configured with opts.
+// A Wrapper with no options behaves exactly like the package-level Wrap.
+func NewWrapper(opts ...Option) *Wrapper {
+	wr := new(Wrapper)
+	for _, opt := range opts {
+		opt(wr)
+	}
+	return wr
+}
+
+var defaultWrapper = NewWrapper()
+
+// With returns a new Wrapper with wr's options, followed by opts.
+// wr is unchanged.
+// This is convenient for options that vary by route:
+//
+//	api := hh.NewWrapper(hh.WithErrorwareGuard(nil))
+//	mux.HandleFunc("GET /thing/{id}", api.With(hh.WithRouteName("thing")).Wrap(srv.handleThing))
+func (wr *Wrapper) With(opts ...Option) *Wrapper {
+	c := *wr
+	// Options modify these in place; don't share them with wr.
+	c.templates = maps.Clone(wr.templates)
+	c.headers = maps.Clone(wr.headers)
+	c.after = slices.Clip(wr.after)
+	c.async = slices.Clip(wr.async)
+	c.responseHooks = slices.Clip(wr.responseHooks)
+	c.trustedProxies = slices.Clip(wr.trustedProxies)
+	c.internalNetworks = slices.Clip(wr.internalNetworks)
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// Wrap converts h to a standard http.HandlerFunc.
+// It behaves like the package-level Wrap, modified by wr's options.
+func (wr *Wrapper) Wrap(h HandlerFunc, errorware ...func(*http.Request, error) error) http.HandlerFunc {
+	if wr.concurrency > 0 {
+		h = limitConcurrency(h, wr.concurrency, wr.onReject)
+	}
+	if wr.flightKey != nil {
+		// outside the concurrency limit: waiting requests don't occupy a slot
+		h = wr.singleFlight(h)
+	}
+	if wr.cacheKey != nil {
+		// outside the concurrency limit and single flight: hits don't wait for either
+		h = wr.responseCache(h)
+	}
+	if wr.requestGuards != nil {
+		h = guardRequests(h, wr.requestGuards)
+	}
+	return wr.handlerFunc(h, errorware, wr.serve)
+}
+
+// A serveFunc serves a request using a handler and errorware.
+type serveFunc func(http.ResponseWriter, *http.Request, HandlerFunc, []func(*http.Request, error) error)
+
+// handlerFunc returns an http.HandlerFunc that serves requests with h and errorware using serve,
+// subject to WithDrain.
+func (wr *Wrapper) handlerFunc(h HandlerFunc, errorware []func(*http.Request, error) error, serve serveFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d := wr.drainer; d != nil {
+			if !d.enter() {
+				serve(w, r, rejectDraining, errorware)
+				return
+			}
+			defer d.exit()
+		}
+		serve(w, r, h, errorware)
+	}
+}
+
+// serve serves r using h.
+func (wr *Wrapper) serve(w http.ResponseWriter, r *http.Request, h HandlerFunc, errorware []func(*http.Request, error) error) {
+	if wr.metrics != nil {
+		wr.metrics.requests.Add(1)
+		defer wr.metrics.countPanic()
+	}
+	r, st := wr.attachState(r)
+	if wr.softTimeout > 0 && (wr.timeout <= 0 || wr.softTimeout < wr.timeout) && wr.onSoftTimeout != nil {
+		t := time.AfterFunc(wr.softTimeout, func() { wr.onSoftTimeout(r) })
+		defer t.Stop()
+	}
+	var before http.Header
+	if wr.writerGuard != nil {
+		before = w.Header().Clone()
+	}
+	out := wr.newOutputWriter(w, r)
+	bufw, err := wr.callRetry(h, r, out)
+	if wr.writerGuard != nil && !bufw.passThrough {
+		wr.checkWriter(w, r, before)
+	}
+	if wr.onOversize != nil && !bufw.passThrough {
+		wr.checkOversize(r, bufw.written)
+	}
+	if !bufw.passThrough {
+		if err == nil && bufw.err == nil && len(wr.responseHooks) > 0 {
+			err = wr.runResponseHooks(r, bufw)
+		}
+		wr.validate(bufw)
+		if err == nil && bufw.err == nil && wr.captureHTTPError && bufw.isHTTPError() {
+			err = Error(bufw.code)
+		}
+	}
+	if bufw.err != nil {
+		if err != nil {
+			err = fmt.Errorf("response write error (%w) after handler error: %w", writeError{bufw.err}, err)
+		} else {
+			err = bufw.err
+		}
+	}
+	failed := err != nil
+	err = wr.applyErrorware(r, err, errorware)
+	handled := errors.Is(err, ErrHandled)
+	if !bufw.passThrough && !handled {
+		st.applyHeader(out.Header())
+	}
+	switch {
+	case bufw.passThrough:
+		// The response is already underway; err cannot be rendered.
+		if err == nil {
+			bufw.sendTrailers(out)
+		}
+	case handled:
+		// Send nothing at all.
+		bufw.buffer.Reset()
+		wr.finish(r, out, err)
+		return
+	case err == nil && failed && wr.emptyOnResolve:
+		out.WriteHeader(http.StatusOK)
+	case err == nil:
+		bufw.flush(out)
+	default:
+		wr.render(out, r, err)
+	}
+	if out.code == 0 {
+		// nothing was written; send the header now, so that finishHeader applies
+		out.WriteHeader(http.StatusOK)
+	}
+	out.writeTrailers()
+	bufw.buffer.Reset()
+	wr.finish(r, out, err)
+}
+
+// A writeError is a response write error that accompanies a handler error.
+// It matches its underlying error using errors.Is, but is not itself an HTTPResponseError,
+// so that the handler's error determines the response.
+type writeError struct {
+	err error
+}
+
+func (e writeError) Error() string { return e.err.Error() }
+
+func (e writeError) Is(target error) bool { return errors.Is(e.err, target) }
+
+// applyErrorware passes err through the default errorware (see SetDefaultErrorware),
+// then errorware, in order.
+func (wr *Wrapper) applyErrorware(r *http.Request, err error, errorware []func(*http.Request, error) error) error {
+	var defaults []func(*http.Request, error) error
+	if p := defaultErrorware.Load(); p != nil {
+		defaults = *p
+	}
+	for i, fn := range defaults {
+		err = wr.callErrorware(r, i, fn, err)
+	}
+	for i, fn := range errorware {
+		err = wr.callErrorware(r, len(defaults)+i, fn, err)
+	}
+	return err
+}
+
+// callErrorware passes err through fn, the ith errorware.
+func (wr *Wrapper) callErrorware(r *http.Request, i int, fn func(*http.Request, error) error, err error) error {
+	prev := err
+	err = fn(r, err)
+	if wr.guard != nil {
+		wr.checkErrorware(r, i, fn, prev, err)
+	}
+	return err
+}
+
+// finish reports the outcome of a request, whose response has been written to out,
+// to wr's observers.
+func (wr *Wrapper) finish(r *http.Request, out *outputWriter, err error) {
+	for _, fn := range wr.after {
+		fn(r, Result{StatusCode: out.status(), Size: out.size, Err: err})
+	}
+	if wr.metrics != nil {
+		wr.metrics.record(out.status(), err)
+	}
+	if wr.requestLog != nil {
+		wr.requestLog.log(r, out, err)
+	}
+	if len(wr.async) > 0 {
+		wr.observeAsync(r, err)
+	}
+}
+
+// callOnce calls h once, buffering its output, subject to any time limit.
+// dst is where the response will be sent; see newBufferingResponseWriter.
+func (wr *Wrapper) callOnce(h HandlerFunc, r *http.Request, dst http.ResponseWriter) (*bufferingResponseWriter, error) {
+	if wr.timeout > 0 {
+		return wr.callTimeout(h, r)
+	}
+	return wr.call(h, r, dst)
+}
+
+// call calls h, buffering its output, and recovering any panic; see PanicError.
+func (wr *Wrapper) call(h HandlerFunc, r *http.Request, dst http.ResponseWriter) (bufw *bufferingResponseWriter, err error) {
+	bufw = wr.newBufferingResponseWriter(r, dst)
+	defer wr.recoverPanic(&err)
+	err = h(bufw, r)
+	bufw.checkLateHeader()
+	return bufw, err
+}
+
+// newBufferingResponseWriter returns a new bufferingResponseWriter for a response to r.
+// If dst is non-nil, it is where the response will be sent.
+// The bufferingResponseWriter forwards some http.ResponseController operations to it,
+// and may switch to writing to it directly; see WithMaxBufferSize.
+func (wr *Wrapper) newBufferingResponseWriter(r *http.Request, dst http.ResponseWriter) *bufferingResponseWriter {
+	bufw := &bufferingResponseWriter{head: r.Method == http.MethodHead, dst: dst, nilAsEmpty: wr.jsonNilAsEmpty}
+	if wr.maxBuffer > 0 && dst != nil {
+		bufw.max = wr.maxBuffer
+		if wr.onOversize != nil {
+			bufw.onPassThrough = func(size int64) { wr.checkOversize(r, size) }
+		}
+	}
+	if wr.newBuffer != nil {
+		bufw.buffer = wr.newBuffer()
+	} else {
+		bufw.buffer = &bufw.buf
+	}
+	return bufw
+}
+
+// validate checks the buffered response in bufw against wr's limits,
+// recording any violation in bufw.err.
+func (wr *Wrapper) validate(bufw *bufferingResponseWriter) {
+	if wr.maxHeaders > 0 && len(bufw.header) > wr.maxHeaders {
+		bufw.setError(ErrorText(http.StatusInternalServerError, "too many response headers"))
+	}
+	if wr.enforceJSON {
+		wr.checkJSON(bufw)
+	}
+	if wr.schemas != nil {
+		wr.checkSchema(bufw)
+	}
+}
+
+// finishHeader makes final adjustments to h, the header of a response about to be sent.
+func (wr *Wrapper) finishHeader(h http.Header) {
+	for k, v := range wr.headers {
+		if _, ok := h[k]; !ok {
+			h[k] = slices.Clone(v)
+		}
+	}
+	if wr.cookieDefaults != nil {
+		applyCookieDefaults(h, wr.cookieDefaults)
+	}
+}
+
+// render writes the response for the non-nil error err to w.
+func (wr *Wrapper) render(w http.ResponseWriter, r *http.Request, err error) {
+	re := asHTTPResponseError(err)
+	if re == nil && wr.fallbackRender != nil {
+		wr.fallbackRender(w, r, err)
+		if out, ok := w.(*outputWriter); !ok || out.code != 0 {
+			return
+		}
+		// The fallback rendered nothing; render as usual.
+	}
+	if re == nil {
+		// not an HTTPResponseError, convert to 500
+		text := http.StatusText(http.StatusInternalServerError)
+		if wr.isInternal(r) {
+			text += ": " + err.Error()
+		}
+		re = &ResponseError{StatusCode: http.StatusInternalServerError, StatusText: text}
+	}
+	if e, ok := re.(*ResponseError); ok && wr.renderTemplate(w, e) {
+		return
+	}
+	if rr, ok := re.(HTTPRequestRenderer); ok {
+		rr.RenderHTTPRequest(w, r)
+		return
+	}
+	re.RenderHTTP(w)
+}